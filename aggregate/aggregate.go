@@ -0,0 +1,282 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rybit/extractor/sink"
+	"github.com/rybit/extractor/stats"
+)
+
+const defaultMaxSeries = 10000
+const defaultMaxSamples = 1000
+
+var defaultPercentiles = []float64{50, 90, 99}
+
+type Config struct {
+	Interval    int       `mapstructure:"flush_sec"`
+	MaxSeries   int       `mapstructure:"max_series"`
+	MaxSamples  int       `mapstructure:"max_samples"`
+	Percentiles []float64 `mapstructure:"percentiles"`
+}
+
+type series struct {
+	name    string
+	dims    map[string]interface{}
+	subject string
+	counter bool
+
+	count   int64
+	sum     int64
+	min     int64
+	max     int64
+	samples []int64
+}
+
+// Aggregator groups metrics by their name and dimensions and publishes them to
+// the next sink once per window. Counters and histogram buckets are summed and
+// published as counters, everything else is summarized into gauges.
+type Aggregator struct {
+	config *Config
+	next   sink.Sink
+	log    *logrus.Entry
+
+	lock   sync.Mutex
+	series map[string]*series
+
+	stop    chan bool
+	stopped chan bool
+}
+
+func New(config *Config, next sink.Sink, log *logrus.Entry) *Aggregator {
+	if config.MaxSeries == 0 {
+		config.MaxSeries = defaultMaxSeries
+	}
+	if config.MaxSamples == 0 {
+		config.MaxSamples = defaultMaxSamples
+	}
+	if len(config.Percentiles) == 0 {
+		config.Percentiles = defaultPercentiles
+	}
+
+	return &Aggregator{
+		config:  config,
+		next:    next,
+		log:     log,
+		series:  make(map[string]*series),
+		stop:    make(chan bool),
+		stopped: make(chan bool),
+	}
+}
+
+func (a *Aggregator) Name() string {
+	return "aggregator"
+}
+
+// Publish will put the metric into the current window. If there are already too many
+// series in the window the metric is dropped. The timestamp isn't used, the window
+// is published when it is flushed.
+func (a *Aggregator) Publish(m *sink.Metric) error {
+	p := point{
+		name:    m.Name,
+		value:   m.Value,
+		dims:    m.Dims,
+		subject: m.Subject,
+	}
+
+	switch m.Kind {
+	case sink.GaugeKind, sink.TimerKind:
+	case sink.HistogramKind:
+		p.dims = make(map[string]interface{}, len(m.Dims)+1)
+		for k, v := range m.Dims {
+			p.dims[k] = v
+		}
+		p.dims["bucket"] = m.Bucket
+		p.value = 1
+		p.counter = true
+	default:
+		p.counter = true
+	}
+
+	if !a.add(p) {
+		return fmt.Errorf("too many series, dropping %s", m.Name)
+	}
+	return nil
+}
+
+type point struct {
+	name    string
+	value   int64
+	dims    map[string]interface{}
+	subject string
+	counter bool
+}
+
+func (a *Aggregator) add(p point) bool {
+	key := p.subject + "|" + seriesKey(p.name, p.dims)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	s, ok := a.series[key]
+	if !ok {
+		if len(a.series) >= a.config.MaxSeries {
+			stats.Increment("aggregation_series_dropped")
+			return false
+		}
+		s = &series{
+			name:    p.name,
+			dims:    p.dims,
+			subject: p.subject,
+			counter: p.counter,
+			min:     p.value,
+			max:     p.value,
+		}
+		a.series[key] = s
+	}
+
+	s.count++
+	s.sum += p.value
+	if p.value < s.min {
+		s.min = p.value
+	}
+	if p.value > s.max {
+		s.max = p.value
+	}
+
+	if !s.counter {
+		// keep a uniform sample of the values for the percentiles
+		if len(s.samples) < a.config.MaxSamples {
+			s.samples = append(s.samples, p.value)
+		} else if i := rand.Int63n(s.count); i < int64(len(s.samples)) {
+			s.samples[i] = p.value
+		}
+	}
+
+	return true
+}
+
+// Run will flush the window every interval until it is stopped
+func (a *Aggregator) Run() {
+	a.log.WithFields(logrus.Fields{
+		"interval":    a.config.Interval,
+		"max_series":  a.config.MaxSeries,
+		"percentiles": a.config.Percentiles,
+	}).Infof("Starting to aggregate metrics every %d seconds", a.config.Interval)
+
+	ticks := time.NewTicker(time.Duration(a.config.Interval) * time.Second)
+	defer ticks.Stop()
+	for {
+		select {
+		case <-ticks.C:
+			a.flushWindow()
+		case <-a.stop:
+			a.flushWindow()
+			close(a.stopped)
+			return
+		}
+	}
+}
+
+// Stop will flush what is left in the window and stop the aggregator
+func (a *Aggregator) Stop() {
+	a.stop <- true
+	<-a.stopped
+}
+
+// Flush will publish everything in the current window, start a new one and
+// then flush the next sink
+func (a *Aggregator) Flush() error {
+	a.flushWindow()
+	return a.next.Flush()
+}
+
+func (a *Aggregator) flushWindow() {
+	a.lock.Lock()
+	current := a.series
+	a.series = make(map[string]*series)
+	a.lock.Unlock()
+
+	for _, s := range current {
+		if s.counter {
+			a.emit(s, sink.CounterKind, s.name, s.sum)
+			continue
+		}
+
+		a.emit(s, sink.GaugeKind, s.name+".count", s.count)
+		a.emit(s, sink.GaugeKind, s.name+".sum", s.sum)
+		a.emit(s, sink.GaugeKind, s.name+".min", s.min)
+		a.emit(s, sink.GaugeKind, s.name+".max", s.max)
+
+		sort.Sort(int64s(s.samples))
+		for _, p := range a.config.Percentiles {
+			a.emit(s, sink.GaugeKind, s.name+"."+percentileName(p), percentile(s.samples, p))
+		}
+	}
+
+	stats.Increment("aggregation_flushes")
+	a.log.Debugf("Flushed %d series", len(current))
+}
+
+func (a *Aggregator) emit(s *series, kind, name string, value int64) {
+	err := a.next.Publish(&sink.Metric{
+		Name:    name,
+		Kind:    kind,
+		Value:   value,
+		Dims:    s.dims,
+		Subject: s.subject,
+	})
+	if err != nil {
+		a.log.WithError(err).Warnf("Failed to publish aggregate %s", name)
+		return
+	}
+	stats.Increment("aggregates_published")
+}
+
+func seriesKey(name string, dims map[string]interface{}) string {
+	keys := make([]string, 0, len(dims))
+	for k := range dims {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{name}
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, dims[k]))
+	}
+	return strings.Join(parts, "|")
+}
+
+// percentile uses the nearest rank of the sorted values
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func percentileName(p float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
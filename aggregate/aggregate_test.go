@@ -0,0 +1,156 @@
+package aggregate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rybit/extractor/sink"
+	"github.com/rybit/extractor/stats"
+)
+
+var tl = logrus.WithField("testing", true)
+
+type collector struct {
+	out     map[string]*sink.Metric
+	all     []*sink.Metric
+	flushed int
+}
+
+func (c *collector) Name() string { return "collector" }
+func (c *collector) Flush() error { c.flushed++; return nil }
+func (c *collector) Publish(m *sink.Metric) error {
+	c.out[seriesKey(m.Name, m.Dims)] = m
+	c.all = append(c.all, m)
+	return nil
+}
+
+func newCollector() *collector {
+	return &collector{out: make(map[string]*sink.Metric)}
+}
+
+func TestCountersAreSummed(t *testing.T) {
+	c := newCollector()
+	a := New(&Config{Interval: 1}, c, tl)
+
+	for i := 0; i < 5; i++ {
+		a.Publish(&sink.Metric{Name: "requests", Value: 2, Dims: map[string]interface{}{"status": 200}})
+	}
+	a.Publish(&sink.Metric{Name: "requests", Kind: sink.CounterKind, Value: 1, Dims: map[string]interface{}{"status": 500}})
+	assert.NoError(t, a.Flush())
+
+	assert.Len(t, c.out, 2)
+	assert.Equal(t, 1, c.flushed)
+	if m, ok := c.out["requests|status=200"]; assert.True(t, ok) {
+		assert.EqualValues(t, 10, m.Value)
+		assert.Equal(t, sink.CounterKind, m.Kind)
+	}
+	if m, ok := c.out["requests|status=500"]; assert.True(t, ok) {
+		assert.EqualValues(t, 1, m.Value)
+	}
+
+	// the window starts over
+	c.out = make(map[string]*sink.Metric)
+	a.Flush()
+	assert.Len(t, c.out, 0)
+}
+
+func TestSubjectsAreKept(t *testing.T) {
+	c := newCollector()
+	a := New(&Config{Interval: 1}, c, tl)
+
+	dims := map[string]interface{}{"status": 500}
+	a.Publish(&sink.Metric{Name: "errors", Value: 1, Dims: dims, Subject: "errors.nginx"})
+	a.Publish(&sink.Metric{Name: "errors", Value: 2, Dims: dims, Subject: "errors.nginx"})
+	a.Publish(&sink.Metric{Name: "errors", Value: 4, Dims: dims})
+	a.Flush()
+
+	found := map[string]int64{}
+	for _, m := range c.all {
+		found[m.Subject] = m.Value
+	}
+	assert.Equal(t, map[string]int64{"errors.nginx": 3, "": 4}, found)
+}
+
+func TestHistogramsAreCountedPerBucket(t *testing.T) {
+	c := newCollector()
+	a := New(&Config{Interval: 1}, c, tl)
+
+	a.Publish(&sink.Metric{Name: "size", Kind: sink.HistogramKind, Value: 5, Bucket: "10"})
+	a.Publish(&sink.Metric{Name: "size", Kind: sink.HistogramKind, Value: 7, Bucket: "10"})
+	a.Publish(&sink.Metric{Name: "size", Kind: sink.HistogramKind, Value: 70, Bucket: "100"})
+	a.Flush()
+
+	assert.Len(t, c.out, 2)
+	assert.EqualValues(t, 2, c.out["size|bucket=10"].Value)
+	assert.EqualValues(t, 1, c.out["size|bucket=100"].Value)
+}
+
+func TestValuesAreSummarized(t *testing.T) {
+	c := newCollector()
+	a := New(&Config{Interval: 1, Percentiles: []float64{50, 99.9}}, c, tl)
+
+	for i := 1; i <= 100; i++ {
+		a.Publish(&sink.Metric{Name: "dur", Kind: sink.TimerKind, Value: int64(i)})
+	}
+	a.Flush()
+
+	expected := map[string]int64{
+		"dur.count": 100,
+		"dur.sum":   5050,
+		"dur.min":   1,
+		"dur.max":   100,
+		"dur.p50":   50,
+		"dur.p99_9": 100,
+	}
+	assert.Len(t, c.out, len(expected))
+	for name, val := range expected {
+		if m, ok := c.out[name]; assert.True(t, ok, "missing "+name) {
+			assert.EqualValues(t, val, m.Value, name)
+			assert.Equal(t, sink.GaugeKind, m.Kind)
+		}
+	}
+}
+
+func TestMaxSeries(t *testing.T) {
+	stats.Reset()
+	c := newCollector()
+	a := New(&Config{Interval: 1, MaxSeries: 2}, c, tl)
+
+	assert.NoError(t, a.Publish(&sink.Metric{Name: "one", Value: 1}))
+	assert.NoError(t, a.Publish(&sink.Metric{Name: "two", Value: 1}))
+	assert.Error(t, a.Publish(&sink.Metric{Name: "three", Value: 1}))
+	// existing series are still fine
+	assert.NoError(t, a.Publish(&sink.Metric{Name: "one", Value: 1}))
+	a.Flush()
+
+	assert.Len(t, c.out, 2)
+	assert.EqualValues(t, 2, c.out["one"].Value)
+	assert.EqualValues(t, 1, stats.Get("aggregation_series_dropped"))
+}
+
+func TestStopFlushes(t *testing.T) {
+	c := newCollector()
+	a := New(&Config{Interval: 60}, c, tl)
+	go a.Run()
+
+	a.Publish(&sink.Metric{Name: "one", Value: 1})
+	a.Stop()
+	assert.Len(t, c.out, 1)
+}
+
+type failing struct{}
+
+func (failing) Name() string                 { return "failing" }
+func (failing) Flush() error                 { return errors.New("nope") }
+func (failing) Publish(m *sink.Metric) error { return errors.New("nope") }
+
+func TestFailedPublish(t *testing.T) {
+	stats.Reset()
+	a := New(&Config{Interval: 1}, failing{}, tl)
+	a.Publish(&sink.Metric{Name: "one", Value: 1})
+	assert.Error(t, a.Flush())
+	assert.EqualValues(t, 0, stats.Get("aggregates_published"))
+}
@@ -0,0 +1,156 @@
+package checkpoint
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fingerprintLen is how much of the head of a file is hashed to tell
+// apart two files that happen to share an inode
+const fingerprintLen int64 = 1024
+
+const (
+	// FallbackResume will use the stored offset even if the file doesn't match
+	FallbackResume = "resume"
+	// FallbackStart will read the file from the beginning
+	FallbackStart = "start"
+	// FallbackEnd will skip to the end of the file
+	FallbackEnd = "end"
+)
+
+type Config struct {
+	File     string `mapstructure:"file"`
+	Interval int    `mapstructure:"flush_sec"`
+
+	// what to do when there is no checkpoint or it doesn't match the file.
+	// It supports "resume", "start", "end" - empty uses the command's default
+	Fallback string `mapstructure:"fallback"`
+}
+
+type Entry struct {
+	Inode          uint64    `json:"inode"`
+	Offset         int64     `json:"offset"`
+	Fingerprint    string    `json:"fingerprint"`
+	FingerprintLen int64     `json:"fingerprint_len"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Store keeps the offsets of the files being consumed and persists them
+// to a small state file so that they survive a restart
+type Store struct {
+	path    string
+	lock    sync.Mutex
+	entries map[string]Entry
+	dirty   bool
+}
+
+// Load will read the state file at the path, a missing file is an empty store
+func Load(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if len(bs) > 0 {
+		if err := json.Unmarshal(bs, &s.entries); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) Get(path string) (Entry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[path]
+	return e, ok
+}
+
+func (s *Store) Set(path string, e Entry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e.UpdatedAt = time.Now()
+	s.entries[path] = e
+	s.dirty = true
+}
+
+// Flush writes the state file if anything has changed. It writes to a
+// temp file and renames it so a crash never leaves a partial state file.
+func (s *Store) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.dirty {
+		return nil
+	}
+
+	bs, err := json.Marshal(&s.entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// Fingerprint hashes the head of the file, up to the size provided
+func Fingerprint(r io.ReaderAt, size int64) (string, int64, error) {
+	if size > fingerprintLen {
+		size = fingerprintLen
+	}
+
+	buf := make([]byte, size)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+
+	sum := sha1.Sum(buf[:n])
+	return hex.EncodeToString(sum[:]), int64(n), nil
+}
+
+// Matches checks if the file is the same one that the entry was made from
+func (e Entry) Matches(r io.ReaderAt, inode uint64) bool {
+	if e.Inode != inode {
+		return false
+	}
+
+	fp, n, err := Fingerprint(r, e.FingerprintLen)
+	if err != nil || n != e.FingerprintLen {
+		return false
+	}
+
+	return fp == e.Fingerprint
+}
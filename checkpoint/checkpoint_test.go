@@ -0,0 +1,64 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Load(filepath.Join(dir, "state.json"))
+	require.NoError(t, err)
+	_, ok := s.Get("/var/log/nothing.log")
+	assert.False(t, ok)
+}
+
+func TestFlushAndReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	s, err := Load(path)
+	require.NoError(t, err)
+
+	s.Set("/var/log/batman.log", Entry{Inode: 12, Offset: 123, Fingerprint: "abc", FingerprintLen: 3})
+	require.NoError(t, s.Flush())
+
+	reloaded, err := Load(path)
+	require.NoError(t, err)
+	if e, ok := reloaded.Get("/var/log/batman.log"); assert.True(t, ok) {
+		assert.EqualValues(t, 12, e.Inode)
+		assert.EqualValues(t, 123, e.Offset)
+		assert.Equal(t, "abc", e.Fingerprint)
+		assert.EqualValues(t, 3, e.FingerprintLen)
+		assert.False(t, e.UpdatedAt.IsZero())
+	}
+
+	// no temp files left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestMatches(t *testing.T) {
+	r := strings.NewReader("this is a line\nthis is another line\n")
+	fp, n, err := Fingerprint(r, 15)
+	require.NoError(t, err)
+	assert.EqualValues(t, 15, n)
+
+	e := Entry{Inode: 1, Fingerprint: fp, FingerprintLen: n}
+	assert.True(t, e.Matches(r, 1))
+	assert.False(t, e.Matches(r, 2))
+	assert.False(t, e.Matches(strings.NewReader("this is not the same\n"), 1))
+	assert.False(t, e.Matches(strings.NewReader("short"), 1))
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/rybit/extractor/conf"
+	"github.com/rybit/extractor/parsing"
+	"github.com/rybit/extractor/tail"
+)
+
+var initName string
+var initMaxLines int
+
+var initCmd = &cobra.Command{
+	Short: "init [file of sample lines]",
+	Long:  "Look at sample lines from a file or stdin and print a starter config with a metric that has a field for each key",
+	Use:   "init",
+	Run:   initConfig,
+}
+
+func init() {
+	initCmd.Flags().StringVarP(&initName, "name", "n", "metric", "the name of the metric")
+	initCmd.Flags().IntVar(&initMaxLines, "max-lines", 1000, "the most lines to look at")
+}
+
+// the config is written with the same keys it is read with
+type initFile struct {
+	Metrics []initMetric `json:"metrics"`
+}
+
+type initMetric struct {
+	Name            string      `json:"name"`
+	TimestampField  *int        `json:"timestamp_index,omitempty"`
+	TimestampFormat string      `json:"timestamp_format,omitempty"`
+	Fields          []initField `json:"fields"`
+}
+
+type initField struct {
+	Position  int               `json:"position"`
+	Label     string            `json:"label,omitempty"`
+	Type      parsing.FieldType `json:"type"`
+	Delimiter string            `json:"delim,omitempty"`
+	Required  bool              `json:"required,omitempty"`
+}
+
+func initConfig(cmd *cobra.Command, args []string) {
+	var in io.Reader = os.Stdin
+	if len(args) > 0 && args[0] != tail.StdinPath {
+		f, err := os.Open(args[0])
+		if err != nil {
+			logrus.WithError(err).Fatalf("Failed to open %s", args[0])
+		}
+		defer f.Close()
+		in = f
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+	for len(lines) < initMaxLines && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.WithError(err).Fatalf("Failed to read line %d", len(lines)+1)
+	}
+
+	m, notes := conf.InferMetric(initName, lines, defaultDelim)
+	for _, note := range notes {
+		fmt.Fprintf(cmd.OutOrStderr(), "note: %s\n", note)
+	}
+
+	out := initMetric{
+		Name:            m.Name,
+		TimestampField:  m.TimestampField,
+		TimestampFormat: m.TimestampFormat,
+		Fields:          []initField{},
+	}
+	for _, f := range m.Fields {
+		out.Fields = append(out.Fields, initField{
+			Position:  f.Position,
+			Label:     f.Label,
+			Type:      f.Type,
+			Delimiter: f.Delimiter,
+			Required:  f.Required,
+		})
+	}
+
+	raw, err := json.MarshalIndent(initFile{Metrics: []initMetric{out}}, "", "  ")
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to write the config")
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), string(raw))
+}
@@ -2,30 +2,49 @@ package cmd
 
 import (
 	"io"
+	"os"
 
 	"github.com/rybit/extractor/tail"
-	"github.com/rybit/nats_metrics"
 	"github.com/spf13/cobra"
 )
 
 var processCmd = &cobra.Command{
-	Short: "process <path>",
+	Short: "process [path or glob]...",
 	Use:   "process",
-	Run:   processSingleFile,
+	Run:   processFiles,
 }
 
-func processSingleFile(cmd *cobra.Command, args []string) {
-	config, log := setup(cmd)
+func processFiles(cmd *cobra.Command, args []string) {
+	config, out, nc, log := setup(cmd)
 
-	if len(args) != 1 {
-		log.Fatal("Must provide a path to consume")
+	if len(args) == 0 {
+		args = config.SourcePaths()
 	}
+	if len(args) == 0 {
+		log.Fatal("Must provide at least one path to consume")
+	}
+
+	signals := notifyOnSignal()
+	p := tail.ProcessFiles(config, args, out, log, io.SeekStart, false)
 
-	if config.Dims != nil {
-		for k, v := range *config.Dims {
-			metrics.AddDimension(k, v)
-		}
+	finished := make(chan bool)
+	go func() {
+		p.Wait()
+		close(finished)
+	}()
+
+	status := 0
+	select {
+	case <-finished:
+		log.Info("Finished processing the files")
+	case sig := <-signals:
+		// we didn't get through everything
+		log.Warnf("Got %s before all the files were processed, shutting down", sig)
+		status = 1
 	}
 
-	tail.ProcessFile(config, args[0], log, io.SeekStart, false)
+	if s := shutdown(config, p, out, nc, signals, log); s != 0 {
+		status = s
+	}
+	os.Exit(status)
 }
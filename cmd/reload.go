@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/rybit/extractor/conf"
+	"github.com/rybit/extractor/stats"
+	"github.com/rybit/extractor/tail"
+)
+
+const configCheckInterval = 5 * time.Second
+
+// reloadOnChange will reload the metrics when the config file changes or on SIGHUP
+func reloadOnChange(cmd *cobra.Command, p *tail.Processor, log *logrus.Entry) {
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+
+	changes := make(chan bool, 1)
+	if path := conf.ConfigFileUsed(); path != "" {
+		log.WithField("config_file", path).Debug("Watching the config file for changes")
+		go watchFile(path, configCheckInterval, changes)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-hups:
+				log.Info("Got SIGHUP, reloading the config")
+			case <-changes:
+				log.Info("The config file changed, reloading it")
+			}
+			reloadConfig(cmd, p, log)
+		}
+	}()
+}
+
+// reloadConfig loads and checks the config the same way as starting up. If anything is
+// wrong with it the old one is kept.
+func reloadConfig(cmd *cobra.Command, p *tail.Processor, log *logrus.Entry) {
+	config, err := conf.LoadConfig(cmd)
+	if err == nil {
+		err = config.AddFieldOverrides(cmdLineFields, defaultDelim)
+	}
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		log.WithError(err).Warn("Failed to reload the config, keeping the old one")
+		stats.Increment("config_reload_failures")
+		return
+	}
+
+	setDefaults(config)
+	p.Reload(config)
+	stats.Increment("config_reloads")
+	log.WithField("metrics", len(config.Metrics)).Info("Reloaded the config")
+}
+
+// watchFile polls the file and sends on changes when its size or modification time is
+// different. It keeps polling if the file is missing, an editor might be replacing it.
+func watchFile(path string, interval time.Duration, changes chan bool) {
+	last, _ := os.Stat(path)
+	ticks := time.NewTicker(interval)
+	defer ticks.Stop()
+	for range ticks.C {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+
+		select {
+		case changes <- true:
+		default:
+		}
+	}
+}
@@ -2,22 +2,28 @@ package cmd
 
 import (
 	"github.com/Sirupsen/logrus"
+	"github.com/nats-io/nats"
 	"github.com/rybit/nats_logrus_hook"
 	"github.com/rybit/nats_metrics"
 	"github.com/spf13/cobra"
 
-	"encoding/json"
-
 	"io"
+	"os"
 
+	"github.com/rybit/extractor/aggregate"
 	"github.com/rybit/extractor/conf"
 	"github.com/rybit/extractor/messaging"
+	"github.com/rybit/extractor/prom"
+	"github.com/rybit/extractor/sink"
 	"github.com/rybit/extractor/stats"
 	"github.com/rybit/extractor/tail"
 )
 
 const defaultRetrySec = 5
 const defaultReportSec = 60
+const defaultCheckpointSec = 5
+const defaultAggregateSec = 10
+const defaultShutdownSec = 10
 
 var defaultDelim string
 var cmdLineFields = []string{}
@@ -26,39 +32,42 @@ func RootCmd() *cobra.Command {
 	rootCmd := &cobra.Command{}
 
 	followCmd := &cobra.Command{
-		Short: "follow <path>",
+		Short: "follow [path or glob]...",
 		Use:   "follow",
 		Run:   run,
 	}
 
-	rootCmd.AddCommand(followCmd, processCmd, versionCmd)
+	rootCmd.AddCommand(followCmd, processCmd, initCmd, testCmd, validateCmd, versionCmd)
 
 	rootCmd.PersistentFlags().StringP("config", "c", "config.json", "a configruation file to use")
 	rootCmd.PersistentFlags().StringVarP(&defaultDelim, "delim", "d", "=", "the delimiter to use for fields")
-	rootCmd.PersistentFlags().StringSliceVarP(&cmdLineFields, "field", "f", cmdLineFields, "field overrides in the form: 'TODO'")
+	rootCmd.PersistentFlags().StringSliceVarP(&cmdLineFields, "field", "f", cmdLineFields, "field overrides in the form: '[metric_name/][!]position[:label[:type]]'")
 
 	return rootCmd
 }
 
 func run(cmd *cobra.Command, args []string) {
-	config, log := setup(cmd)
+	config, out, nc, log := setup(cmd)
 
-	if len(args) != 1 {
-		log.Fatal("Must provide a path to consume")
+	if len(args) == 0 {
+		args = config.SourcePaths()
 	}
-
-	if config.Dims != nil {
-		for k, v := range *config.Dims {
-			metrics.AddDimension(k, v)
-		}
+	if len(args) == 0 {
+		log.Fatal("Must provide at least one path to consume")
 	}
+
 	stats.ReportStats(config.ReportConf, log, config.Dims)
-	tail.ProcessFile(config, args[0], log, io.SeekEnd, true)
 
-	select {}
+	signals := notifyOnSignal()
+	p := tail.ProcessFiles(config, args, out, log, io.SeekEnd, true)
+	reloadOnChange(cmd, p, log.WithField("component", "reloader"))
+
+	sig := <-signals
+	log.Infof("Got %s, shutting down", sig)
+	os.Exit(shutdown(config, p, out, nc, signals, log))
 }
 
-func setup(cmd *cobra.Command) (*conf.Config, *logrus.Entry) {
+func setup(cmd *cobra.Command) (*conf.Config, sink.Sink, *nats.Conn, *logrus.Entry) {
 	config, err := conf.LoadConfig(cmd)
 	if err != nil {
 		logrus.Fatalf("Failed to load configuration: %v", err)
@@ -68,18 +77,19 @@ func setup(cmd *cobra.Command) (*conf.Config, *logrus.Entry) {
 		log.Fatalf("Failed to configure logging : %v", err)
 	}
 
-	if len(config.Metrics) == 0 {
-		log.Fatal("Must provide at least one metric to extract")
+	if err := config.AddFieldOverrides(cmdLineFields, defaultDelim); err != nil {
+		log.WithError(err).Fatal("Failed to parse the command line fields")
 	}
 
-	if config.ReportConf != nil {
-		if config.ReportConf.Interval == 0 {
-			config.ReportConf.Interval = defaultReportSec
-		}
+	if err := config.Validate(); err != nil {
+		log.Fatalf("The configuration is invalid:\n%v", err)
 	}
 
+	setDefaults(config)
+
+	var nc *nats.Conn
 	if config.NatsConf != nil {
-		nc, err := messaging.ConnectToNats(config.NatsConf, messaging.ErrorHandler(log))
+		nc, err = messaging.ConnectToNats(config.NatsConf, messaging.ErrorHandler(log))
 		if err != nil {
 			log.WithError(err).Fatal("Failed to connect to nats")
 		}
@@ -95,19 +105,115 @@ func setup(cmd *cobra.Command) (*conf.Config, *logrus.Entry) {
 			log.WithField("log_subject", config.NatsConf.LogSubject).Debug("Configured nats hook into logrus")
 		}
 	} else {
-		log.Debug("No nats config specified - going to output using logger")
+		log.Debug("No nats config specified")
 		metrics.Init(nil, "nowhere")
-		metrics.Trace(func(m *metrics.RawMetric) {
-			bs, err := json.Marshal(m)
-			if err == nil {
-				log.Info(string(bs))
-			}
-		})
+	}
+
+	out := configureSinks(config, nc, log)
+
+	return config, out, nc, log
+}
+
+func setDefaults(config *conf.Config) {
+	if config.ReportConf != nil {
+		if config.ReportConf.Interval == 0 {
+			config.ReportConf.Interval = defaultReportSec
+		}
+	}
+
+	if config.CheckpointConf != nil {
+		if config.CheckpointConf.Interval == 0 {
+			config.CheckpointConf.Interval = defaultCheckpointSec
+		}
+	}
+
+	if config.AggregateConf != nil {
+		if config.AggregateConf.Interval == 0 {
+			config.AggregateConf.Interval = defaultAggregateSec
+		}
 	}
 
 	if config.RetrySec == 0 {
 		config.RetrySec = defaultRetrySec
 	}
+	if config.ShutdownSec == 0 {
+		config.ShutdownSec = defaultShutdownSec
+	}
+}
+
+func configureSinks(config *conf.Config, nc *nats.Conn, log *logrus.Entry) sink.Sink {
+	names := config.Sinks
+	if len(names) == 0 {
+		if config.NatsConf != nil {
+			names = append(names, conf.NatsSink)
+		}
+		if config.PromConf != nil {
+			names = append(names, conf.PrometheusSink)
+		}
+		if config.StatsdConf != nil {
+			names = append(names, conf.StatsdSink)
+		}
+		if config.InfluxConf != nil {
+			names = append(names, conf.InfluxSink)
+		}
+		if len(names) == 0 {
+			log.Debug("No sink config specified - going to output using logger")
+			names = append(names, conf.LogSink)
+		}
+	}
 
-	return config, log
+	sinks := []sink.Sink{}
+	for _, name := range names {
+		switch name {
+		case conf.NatsSink:
+			if nc == nil {
+				log.Fatal("Must provide a nats config to use the nats sink")
+			}
+			sinks = append(sinks, sink.NewNatsSink(nc, config.Subject))
+		case conf.LogSink:
+			sinks = append(sinks, sink.NewLogSink(log))
+		case conf.PrometheusSink:
+			if config.PromConf == nil || config.PromConf.Listen == "" {
+				log.Fatal("Must provide an address to serve prometheus metrics on")
+			}
+
+			exporter := prom.NewExporter(config.PromConf, log.WithField("component", "prometheus"))
+			go func() {
+				if err := exporter.ListenAndServe(); err != nil {
+					log.WithError(err).Fatal("Failed to serve prometheus metrics")
+				}
+			}()
+			sinks = append(sinks, exporter)
+		case conf.StatsdSink:
+			if config.StatsdConf == nil {
+				log.Fatal("Must provide a statsd config to use the statsd sink")
+			}
+			s, err := sink.NewStatsdSink(config.StatsdConf, log.WithField("component", "statsd"))
+			if err != nil {
+				log.WithError(err).Fatal("Failed to connect to statsd")
+			}
+			sinks = append(sinks, s)
+		case conf.InfluxSink:
+			if config.InfluxConf == nil {
+				log.Fatal("Must provide an influx config to use the influx sink")
+			}
+			s, err := sink.NewInfluxSink(config.InfluxConf, log.WithField("component", "influx"))
+			if err != nil {
+				log.WithError(err).Fatal("Failed to configure influx")
+			}
+			sinks = append(sinks, s)
+		default:
+			log.Fatalf("Unknown sink '%s'", name)
+		}
+		log.WithField("sink", name).Debug("Configured sink")
+	}
+
+	out := sink.NewMulti(sinks, log.WithField("component", "sinks"))
+
+	if config.AggregateConf != nil {
+		agg := aggregate.New(config.AggregateConf, out, log.WithField("component", "aggregator"))
+		go agg.Run()
+		return agg
+	}
+	return out
 }
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/nats-io/nats"
+
+	"github.com/rybit/extractor/conf"
+	"github.com/rybit/extractor/sink"
+	"github.com/rybit/extractor/stats"
+	"github.com/rybit/extractor/tail"
+)
+
+func notifyOnSignal() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	return signals
+}
+
+// shutdown will stop the files, process the lines that were already read and then flush
+// the sinks, the stats and the NATS connection. Each step has the shutdown timeout and
+// another signal will exit right away. It returns the status to exit with.
+func shutdown(config *conf.Config, p *tail.Processor, out sink.Sink, nc *nats.Conn, signals chan os.Signal, log *logrus.Entry) int {
+	go func() {
+		sig := <-signals
+		log.Warnf("Got %s while shutting down, exiting now", sig)
+		os.Exit(1)
+	}()
+
+	timeout := time.Duration(config.ShutdownSec) * time.Second
+	status := 0
+
+	log.Info("Stopping the files")
+	err := withTimeout(timeout, func() error {
+		p.Stop()
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Warn("Failed to stop all the files")
+		status = 1
+	}
+
+	log.Info("Flushing the sinks")
+	if err := withTimeout(timeout, out.Flush); err != nil {
+		log.WithError(err).Warn("Failed to flush the sinks")
+		status = 1
+	}
+
+	stats.Report(config.ReportConf, log, config.Dims)
+
+	if nc != nil {
+		if err := nc.FlushTimeout(timeout); err != nil {
+			log.WithError(err).Warn("Failed to flush the NATS connection")
+			status = 1
+		}
+		nc.Close()
+	}
+
+	log.WithField("status", status).Info("Finished shutting down")
+	return status
+}
+
+func withTimeout(timeout time.Duration, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/rybit/extractor/conf"
+	"github.com/rybit/extractor/parsing"
+	"github.com/rybit/extractor/tail"
+)
+
+var testSource string
+
+var testCmd = &cobra.Command{
+	Short: "test [file of sample lines]",
+	Long:  "Run sample lines from a file or stdin through the metrics and show what they would publish, without sending anything",
+	Use:   "test",
+	Run:   testLines,
+}
+
+func init() {
+	testCmd.Flags().StringVarP(&testSource, "source", "s", "", "use the metrics of the source that matches this path")
+}
+
+func testLines(cmd *cobra.Command, args []string) {
+	config, err := conf.LoadConfig(cmd)
+	if err != nil {
+		logrus.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := config.AddFieldOverrides(cmdLineFields, defaultDelim); err != nil {
+		logrus.WithError(err).Fatal("Failed to parse the command line fields")
+	}
+
+	defs := config.Metrics
+	if testSource != "" {
+		src := config.SourceFor(testSource)
+		if src == nil {
+			logrus.Fatalf("There is no source that matches '%s'", testSource)
+		}
+		defs = src.Metrics
+	}
+	if len(defs) == 0 {
+		logrus.Fatal("Must provide at least one metric to test")
+	}
+
+	var in io.Reader = os.Stdin
+	if len(args) > 0 && args[0] != tail.StdinPath {
+		f, err := os.Open(args[0])
+		if err != nil {
+			logrus.WithError(err).Fatalf("Failed to open %s", args[0])
+		}
+		defer f.Close()
+		in = f
+	}
+
+	out := cmd.OutOrStdout()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fmt.Fprintf(out, "line %d: %s\n", lineNum, scanner.Text())
+		for _, r := range tail.TryLine(scanner.Text(), config, testSource) {
+			printDryRun(out, r)
+		}
+		fmt.Fprintln(out)
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.WithError(err).Fatalf("Failed to read line %d", lineNum+1)
+	}
+}
+
+func printDryRun(out io.Writer, r tail.DryRun) {
+	fmt.Fprintf(out, "  %s\n", r.Metric)
+	if len(r.Tokens) > 0 {
+		tokens := make([]string, len(r.Tokens))
+		for i, t := range r.Tokens {
+			tokens[i] = fmt.Sprintf("%d:%q", i, t)
+		}
+		fmt.Fprintf(out, "    tokens: %s\n", strings.Join(tokens, " "))
+	}
+
+	for _, idx := range fieldIndexes(r.Fields) {
+		f := r.Fields[idx]
+		fmt.Fprintf(out, "    field %d: %s = %v\n", idx, f.Label, f.Value)
+	}
+	for _, note := range r.Notes {
+		fmt.Fprintf(out, "    note: %s\n", note)
+	}
+
+	if r.Output == nil {
+		fmt.Fprintf(out, "    rejected: %s\n", r.Rejected)
+		return
+	}
+
+	m := r.Output
+	when := "now"
+	if !m.Timestamp.IsZero() {
+		when = m.Timestamp.String()
+	}
+	fmt.Fprintf(out, "    %s %s value=%d timestamp=%s\n", m.Kind, m.Name, m.Value, when)
+	if m.Duration != 0 {
+		fmt.Fprintf(out, "    duration=%s\n", m.Duration)
+	}
+	if m.Bucket != "" {
+		fmt.Fprintf(out, "    bucket=%s\n", m.Bucket)
+	}
+
+	keys := make([]string, 0, len(m.Dims))
+	for k := range m.Dims {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(out, "    dim %s = %v\n", k, m.Dims[k])
+	}
+}
+
+func fieldIndexes(fields map[int]parsing.ParsedField) []int {
+	idxs := make([]int, 0, len(fields))
+	for idx := range fields {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	return idxs
+}
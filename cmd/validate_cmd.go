@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rybit/extractor/conf"
+)
+
+var validateCmd = &cobra.Command{
+	Short: "validate",
+	Long:  "Check the configuration and the command line fields without starting anything",
+	Use:   "validate",
+	Run:   validateConfig,
+}
+
+func validateConfig(cmd *cobra.Command, args []string) {
+	out := cmd.OutOrStdout()
+	fail := func(msg string, err error) {
+		fmt.Fprintf(out, "%s:\n%v\n", msg, err)
+		os.Exit(1)
+	}
+
+	config, err := conf.LoadConfig(cmd)
+	if err != nil {
+		fail("Failed to load the configuration", err)
+	}
+	if err := config.AddFieldOverrides(cmdLineFields, defaultDelim); err != nil {
+		fail("Failed to parse the command line fields", err)
+	}
+	if err := config.Validate(); err != nil {
+		fail("The configuration is invalid", err)
+	}
+
+	fmt.Fprintln(out, "The configuration is valid")
+}
@@ -0,0 +1,178 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/rybit/extractor/parsing"
+)
+
+// Condition is a test on a line and the fields parsed from it. Everything that is set
+// has to pass, a condition with nothing set always passes.
+type Condition struct {
+	// optional, a regex that the raw line has to match
+	Line string `mapstructure:"line"`
+
+	// optional, tests on the value of a field, the index is the same as value_index.
+	// The value is compared as a string with equals and regex, and as a number with
+	// min and max, which are inclusive. A field that wasn't parsed fails them all.
+	FieldIndex *int     `mapstructure:"field_index"`
+	Equals     *string  `mapstructure:"equals"`
+	Regex      string   `mapstructure:"regex"`
+	Min        *float64 `mapstructure:"min"`
+	Max        *float64 `mapstructure:"max"`
+
+	// optional, conditions that all have to pass or at least one has to pass
+	And []Condition `mapstructure:"and"`
+	Or  []Condition `mapstructure:"or"`
+
+	line  *regexp.Regexp
+	regex *regexp.Regexp
+}
+
+func (c *Condition) compile() error {
+	var err error
+	if c.Line != "" {
+		if c.line, err = regexp.Compile(c.Line); err != nil {
+			return fmt.Errorf("invalid line regex: %v", err)
+		}
+	}
+	if c.Regex != "" {
+		if c.regex, err = regexp.Compile(c.Regex); err != nil {
+			return fmt.Errorf("invalid field regex: %v", err)
+		}
+	}
+
+	for i := range c.And {
+		if err := c.And[i].compile(); err != nil {
+			return err
+		}
+	}
+	for i := range c.Or {
+		if err := c.Or[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Passes will test the line and its fields, the condition must be compiled first
+func (c *Condition) Passes(raw string, fields map[int]parsing.ParsedField) bool {
+	if c.line != nil && !c.line.MatchString(raw) {
+		return false
+	}
+
+	if c.FieldIndex != nil && !c.fieldPasses(fields) {
+		return false
+	}
+
+	for i := range c.And {
+		if !c.And[i].Passes(raw, fields) {
+			return false
+		}
+	}
+
+	if len(c.Or) == 0 {
+		return true
+	}
+	for i := range c.Or {
+		if c.Or[i].Passes(raw, fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Condition) fieldPasses(fields map[int]parsing.ParsedField) bool {
+	field, ok := fields[*c.FieldIndex]
+	if !ok {
+		return false
+	}
+
+	value := fmt.Sprintf("%v", field.Value)
+	if c.Equals != nil && value != *c.Equals {
+		return false
+	}
+	if c.regex != nil && !c.regex.MatchString(value) {
+		return false
+	}
+
+	if c.Min != nil || c.Max != nil {
+		num, ok := asFloat(field.Value)
+		if !ok {
+			return false
+		}
+		if c.Min != nil && num < *c.Min {
+			return false
+		}
+		if c.Max != nil && num > *c.Max {
+			return false
+		}
+	}
+	return true
+}
+
+func asFloat(value interface{}) (float64, bool) {
+	switch val := value.(type) {
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case float64:
+		return val, true
+	case string:
+		num, err := strconv.ParseFloat(val, 64)
+		return num, err == nil
+	}
+	return 0, false
+}
+
+// validate checks that the field tests have a field to look at, and that the index
+// is one of the keys of the metric's fields
+func (c *Condition) validate(name string, keys map[int]bool) []string {
+	problems := []string{}
+	fieldTest := c.Equals != nil || c.Regex != "" || c.Min != nil || c.Max != nil
+	switch {
+	case c.FieldIndex == nil && fieldTest:
+		problems = append(problems, fmt.Sprintf("%s needs a field_index for equals, regex, min or max", name))
+	case c.FieldIndex != nil && !fieldTest:
+		problems = append(problems, fmt.Sprintf("%s has a field_index but nothing to test it with", name))
+	case c.FieldIndex != nil && !keys[*c.FieldIndex]:
+		problems = append(problems, fmt.Sprintf("%s.field_index %d doesn't point at a field", name, *c.FieldIndex))
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		problems = append(problems, fmt.Sprintf("%s has a min that is more than the max", name))
+	}
+	if (c.Line != "" && c.line == nil) || (c.Regex != "" && c.regex == nil) {
+		problems = append(problems, fmt.Sprintf("%s hasn't been compiled", name))
+	}
+
+	for i := range c.And {
+		problems = append(problems, c.And[i].validate(fmt.Sprintf("%s.and.%d", name, i), keys)...)
+	}
+	for i := range c.Or {
+		problems = append(problems, c.Or[i].validate(fmt.Sprintf("%s.or.%d", name, i), keys)...)
+	}
+	return problems
+}
+
+var (
+	// ErrNotMatched is when the line doesn't pass the metric's match condition
+	ErrNotMatched = errors.New("the line doesn't pass the match condition")
+	// ErrDropped is when the line passes the metric's drop condition
+	ErrDropped = errors.New("the line passes the drop condition")
+)
+
+// Filter is nil if the metric should be published for the line. The line has to pass
+// the match condition and can't pass the drop condition.
+func (m MetricDef) Filter(raw string, fields map[int]parsing.ParsedField) error {
+	if m.Match != nil && !m.Match.Passes(raw, fields) {
+		return ErrNotMatched
+	}
+	if m.Drop != nil && m.Drop.Passes(raw, fields) {
+		return ErrDropped
+	}
+	return nil
+}
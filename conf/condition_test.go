@@ -0,0 +1,118 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rybit/extractor/parsing"
+)
+
+func TestConditionPasses(t *testing.T) {
+	status, path := 1, 2
+	ok, missing := "GET", "nope"
+	low, high := 500.0, 599.0
+
+	line := "method=GET status=503 path=/health"
+	fields := map[int]parsing.ParsedField{
+		0: {Label: "method", Value: "GET"},
+		1: {Label: "status", Value: 503},
+		2: {Label: "path", Value: "/health"},
+	}
+
+	tests := map[string]struct {
+		c        Condition
+		expected bool
+	}{
+		"empty":         {Condition{}, true},
+		"line":          {Condition{Line: `status=5\d\d`}, true},
+		"line miss":     {Condition{Line: `status=2\d\d`}, false},
+		"equals":        {Condition{FieldIndex: &[]int{0}[0], Equals: &ok}, true},
+		"equals miss":   {Condition{FieldIndex: &[]int{0}[0], Equals: &missing}, false},
+		"equals number": {Condition{FieldIndex: &status, Equals: &[]string{"503"}[0]}, true},
+		"range":         {Condition{FieldIndex: &status, Min: &low, Max: &high}, true},
+		"below min":     {Condition{FieldIndex: &status, Min: &high}, false},
+		"above max":     {Condition{FieldIndex: &status, Max: &low}, false},
+		"not a number":  {Condition{FieldIndex: &path, Min: &low}, false},
+		"regex":         {Condition{FieldIndex: &path, Regex: "^/health"}, true},
+		"missing field": {Condition{FieldIndex: &[]int{7}[0], Regex: ".*"}, false},
+		"and": {Condition{And: []Condition{
+			{FieldIndex: &status, Min: &low},
+			{FieldIndex: &path, Regex: "health"},
+		}}, true},
+		"and miss": {Condition{And: []Condition{
+			{FieldIndex: &status, Min: &low},
+			{FieldIndex: &path, Regex: "users"},
+		}}, false},
+		"or": {Condition{Or: []Condition{
+			{FieldIndex: &path, Regex: "users"},
+			{Line: "GET"},
+		}}, true},
+		"or miss": {Condition{Or: []Condition{
+			{FieldIndex: &path, Regex: "users"},
+			{Line: "POST"},
+		}}, false},
+		"line and or": {Condition{Line: "GET", Or: []Condition{
+			{FieldIndex: &path, Regex: "users"},
+		}}, false},
+	}
+
+	for name, test := range tests {
+		require.NoError(t, test.c.compile(), name)
+		assert.Equal(t, test.expected, test.c.Passes(line, fields), name)
+	}
+}
+
+func TestMetricFilter(t *testing.T) {
+	status := 1
+	low := 500.0
+	m := MetricDef{
+		Name:   "testing-errors",
+		Fields: []parsing.FieldDef{{Position: 0}, {Position: 1, Type: parsing.NumberType}},
+		Match:  &Condition{FieldIndex: &status, Min: &low},
+		Drop:   &Condition{Line: "path=/health"},
+	}
+	require.NoError(t, m.Compile())
+	require.NoError(t, m.Validate())
+
+	log := logrus.WithField("testing", true)
+	filter := func(line string) error {
+		fields, _, ok := m.ParseLine(line, log)
+		require.True(t, ok)
+		return m.Filter(line, fields)
+	}
+	assert.NoError(t, filter("path=/users status=502"))
+	assert.Equal(t, ErrNotMatched, filter("path=/users status=200"))
+	assert.Equal(t, ErrDropped, filter("path=/health status=503"))
+}
+
+func TestValidateConditions(t *testing.T) {
+	zero, five := 0, 5
+	low, high := 500.0, 599.0
+	m := MetricDef{
+		Name:   "bad-conditions",
+		Fields: []parsing.FieldDef{{Position: 0}},
+		Match: &Condition{
+			Regex: "5..",
+			And: []Condition{
+				{FieldIndex: &five, Regex: "x"},
+				{FieldIndex: &zero, Min: &high, Max: &low},
+			},
+		},
+		Drop: &Condition{Or: []Condition{{FieldIndex: &zero}}},
+	}
+	require.NoError(t, m.Compile())
+
+	err := m.Validate()
+	if assert.Error(t, err) {
+		assert.Equal(t, "metric 'bad-conditions': match needs a field_index for equals, regex, min or max\n"+
+			"metric 'bad-conditions': match.and.0.field_index 5 doesn't point at a field\n"+
+			"metric 'bad-conditions': match.and.1 has a min that is more than the max\n"+
+			"metric 'bad-conditions': drop.or.0 has a field_index but nothing to test it with", err.Error())
+	}
+
+	bad := MetricDef{Name: "bad-regex", Drop: &Condition{Line: "("}}
+	assert.Error(t, bad.Compile())
+}
@@ -1,14 +1,25 @@
 package conf
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/rybit/extractor/aggregate"
+	"github.com/rybit/extractor/checkpoint"
 	"github.com/rybit/extractor/messaging"
 	"github.com/rybit/extractor/parsing"
+	"github.com/rybit/extractor/prom"
+	"github.com/rybit/extractor/sink"
 	"github.com/rybit/extractor/stats"
 )
 
@@ -19,11 +30,67 @@ type Config struct {
 	RetrySec int    `mapstructure:"retry_sec"`
 	Subject  string `mapstructure:"subject"`
 
+	// optional, how long each step of shutting down can take (default 10)
+	ShutdownSec int `mapstructure:"shutdown_sec"`
+
+	// optional, the dimension to put the path of the file in
+	SourceDim string `mapstructure:"source_dim"`
+
+	// optional, the longest line to read in bytes (default 1MB). Longer lines are
+	// truncated, or skipped if long_lines is "skip".
+	MaxLineLength int    `mapstructure:"max_line_length"`
+	LongLines     string `mapstructure:"long_lines"`
+
 	Dims       *map[string]interface{} `mapstructure:"dims"`
 	Metrics    []MetricDef             `mapstructure:"metrics"`
 	ReportConf *stats.Config           `mapstructure:"stats_conf"`
+
+	// optional, metrics for specific files. A file that matches a source uses its
+	// metrics instead of the ones above.
+	Sources []SourceDef `mapstructure:"sources"`
+
+	CheckpointConf *checkpoint.Config `mapstructure:"checkpoint_conf"`
+	AggregateConf  *aggregate.Config  `mapstructure:"aggregate_conf"`
+	PromConf       *prom.Config       `mapstructure:"prometheus_conf"`
+	StatsdConf     *sink.StatsdConfig `mapstructure:"statsd_conf"`
+	InfluxConf     *sink.InfluxConfig `mapstructure:"influx_conf"`
+
+	// optional, where to send the metrics. It supports "nats", "log", "prometheus", "statsd" and "influx".
+	// If empty it is each of the ones that are configured, otherwise log.
+	Sinks []string `mapstructure:"sinks"`
 }
 
+const (
+	// SplitFormat splits the line on spaces and each token on the field's delimiter
+	SplitFormat = "split"
+	// RegexFormat uses the capture groups of the metric's pattern
+	RegexFormat = "regex"
+	// JSONFormat decodes each line as a JSON object
+	JSONFormat = "json"
+	// LogfmtFormat breaks the line into key=value pairs, values can be quoted
+	LogfmtFormat = "logfmt"
+)
+
+const (
+	CounterKind   = sink.CounterKind
+	GaugeKind     = sink.GaugeKind
+	TimerKind     = sink.TimerKind
+	HistogramKind = sink.HistogramKind
+)
+
+const (
+	TruncateLongLines = "truncate"
+	SkipLongLines     = "skip"
+)
+
+const (
+	NatsSink       = "nats"
+	LogSink        = "log"
+	PrometheusSink = "prometheus"
+	StatsdSink     = "statsd"
+	InfluxSink     = "influx"
+)
+
 type MetricDef struct {
 	Name       string             `mapstructure:"name"`
 	Fields     []parsing.FieldDef `mapstructure:"fields"`
@@ -34,6 +101,228 @@ type MetricDef struct {
 	// shorthand values "msec", "nano", "sec", or a golang format
 	TimestampFormat string `mapstructure:"timestamp_format"`
 	TimestampField  *int   `mapstructure:"timestamp_index"`
+
+	// optional, how to break the line up into fields. It supports
+	// "split" (the default), "json", "logfmt" or "regex" which requires a pattern.
+	Format  string `mapstructure:"format"`
+	Pattern string `mapstructure:"pattern"`
+	regex   *regexp.Regexp
+
+	// optional, what kind of metric to publish. It supports "counter" (the default),
+	// "gauge", "timer" and "histogram". Everything but a counter needs a value_index.
+	Kind string `mapstructure:"kind"`
+
+	// optional for timers, the unit of the value. It supports "msec" (the default),
+	// "nano", "usec" and "sec"
+	TimerUnit string `mapstructure:"timer_unit"`
+
+	// required for histograms, the upper bounds of the buckets
+	Buckets []int64 `mapstructure:"buckets"`
+
+	// optional, the metric is only published for lines that pass the match
+	// condition and don't pass the drop condition
+	Match *Condition `mapstructure:"match"`
+	Drop  *Condition `mapstructure:"drop"`
+
+	// set if it didn't compile, there is no point validating it too
+	failed bool
+}
+
+// Compile will prepare the metric for parsing lines, it must be called before ParseLine
+func (m *MetricDef) Compile() error {
+	err := m.compile()
+	m.failed = err != nil
+	return err
+}
+
+func (m *MetricDef) compile() error {
+	switch m.Format {
+	case "", SplitFormat, JSONFormat, LogfmtFormat:
+	case RegexFormat:
+		if m.Pattern == "" {
+			return fmt.Errorf("metric '%s' uses the regex format but has no pattern", m.Name)
+		}
+		re, err := regexp.Compile(m.Pattern)
+		if err != nil {
+			return fmt.Errorf("metric '%s' has an invalid pattern: %v", m.Name, err)
+		}
+		m.regex = re
+	default:
+		return fmt.Errorf("metric '%s' has an unknown format '%s'", m.Name, m.Format)
+	}
+
+	if m.Match != nil {
+		if err := m.Match.compile(); err != nil {
+			return fmt.Errorf("metric '%s' has an invalid match: %v", m.Name, err)
+		}
+	}
+	if m.Drop != nil {
+		if err := m.Drop.compile(); err != nil {
+			return fmt.Errorf("metric '%s' has an invalid drop: %v", m.Name, err)
+		}
+	}
+
+	switch m.Kind {
+	case "", CounterKind:
+		return nil
+	case GaugeKind, TimerKind, HistogramKind:
+	default:
+		return fmt.Errorf("metric '%s' has an unknown kind '%s'", m.Name, m.Kind)
+	}
+
+	if m.ValueField == nil {
+		return fmt.Errorf("metric '%s' is a %s and must have a value_index", m.Name, m.Kind)
+	}
+
+	if m.Kind == TimerKind {
+		if _, err := m.Duration(0); err != nil {
+			return err
+		}
+	}
+
+	if m.Kind == HistogramKind {
+		if len(m.Buckets) == 0 {
+			return fmt.Errorf("metric '%s' is a histogram and must have buckets", m.Name)
+		}
+		for i := 1; i < len(m.Buckets); i++ {
+			if m.Buckets[i] <= m.Buckets[i-1] {
+				return fmt.Errorf("metric '%s' must have buckets in increasing order", m.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Duration converts a value of a timer into a duration using its unit
+func (m MetricDef) Duration(value int64) (time.Duration, error) {
+	switch m.TimerUnit {
+	case "", "msec":
+		return time.Duration(value) * time.Millisecond, nil
+	case "nano":
+		return time.Duration(value), nil
+	case "usec":
+		return time.Duration(value) * time.Microsecond, nil
+	case "sec":
+		return time.Duration(value) * time.Second, nil
+	}
+	return 0, fmt.Errorf("metric '%s' has an unknown timer unit '%s'", m.Name, m.TimerUnit)
+}
+
+// Bucket finds the upper bound of the bucket that the value falls in, values past
+// the last bucket are in the "+Inf" bucket
+func (m MetricDef) Bucket(value int64) string {
+	for _, bound := range m.Buckets {
+		if value <= bound {
+			return strconv.FormatInt(bound, 10)
+		}
+	}
+	return "+Inf"
+}
+
+// ParseLine will extract the fields from the line using the metric's format
+func (m MetricDef) ParseLine(raw string, log *logrus.Entry) (map[int]parsing.ParsedField, map[string]interface{}, bool) {
+	switch m.Format {
+	case RegexFormat:
+		if m.regex == nil {
+			log.Warnf("The pattern for metric '%s' hasn't been compiled", m.Name)
+			return nil, nil, false
+		}
+		return parsing.ParseRegexLine(raw, m.regex, m.Fields, log)
+	case JSONFormat:
+		return parsing.ParseJSONLine(raw, m.Fields, log)
+	case LogfmtFormat:
+		return parsing.ParseLogfmtLine(raw, m.Fields, log)
+	}
+	return parsing.ParseLine(raw, m.Fields, log)
+}
+
+// Tokens are the pieces of the line that the positions of the fields point at, the words
+// of a split line or the capture groups of a regex. Other formats use the index of the field.
+func (m MetricDef) Tokens(raw string) []string {
+	switch m.Format {
+	case "", SplitFormat:
+		return strings.Split(raw, " ")
+	case RegexFormat:
+		if m.regex != nil {
+			return m.regex.FindStringSubmatch(raw)
+		}
+	}
+	return nil
+}
+
+// SourceDef binds a list of metrics to the files that match the path, which can be a glob
+type SourceDef struct {
+	Path    string      `mapstructure:"path"`
+	Metrics []MetricDef `mapstructure:"metrics"`
+
+	// optional, added to every metric from the files
+	Dims map[string]interface{} `mapstructure:"dims"`
+	// optional, the subject to publish the metrics on instead of the global one
+	Subject string `mapstructure:"subject"`
+}
+
+// Compile will check the path and compile each of the metrics
+func (s *SourceDef) Compile() error {
+	if s.Path == "" {
+		return fmt.Errorf("a source must have a path")
+	}
+	if _, err := filepath.Match(s.Path, ""); err != nil {
+		return fmt.Errorf("invalid path '%s' for a source: %v", s.Path, err)
+	}
+	if len(s.Metrics) == 0 {
+		return fmt.Errorf("the source for '%s' must have at least one metric", s.Path)
+	}
+
+	problems := []string{}
+	for i := range s.Metrics {
+		if err := s.Metrics[i].Compile(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// SourceFor will find the first source whose path matches, or nil if none do
+func (config *Config) SourceFor(path string) *SourceDef {
+	path = filepath.Clean(path)
+	for i := range config.Sources {
+		s := &config.Sources[i]
+		// the path is checked when the source is compiled
+		if ok, _ := filepath.Match(filepath.Clean(s.Path), path); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// SourcePaths are the paths of all the sources, used when none are given to consume
+func (config *Config) SourcePaths() []string {
+	paths := []string{}
+	for _, s := range config.Sources {
+		paths = append(paths, s.Path)
+	}
+	return paths
+}
+
+// HasMetrics is true if there is at least one metric at the top level or in a source
+func (config *Config) HasMetrics() bool {
+	return len(config.allMetrics()) > 0
+}
+
+func (config *Config) allMetrics() []*MetricDef {
+	all := []*MetricDef{}
+	for i := range config.Metrics {
+		all = append(all, &config.Metrics[i])
+	}
+	for i := range config.Sources {
+		for j := range config.Sources[i].Metrics {
+			all = append(all, &config.Sources[i].Metrics[j])
+		}
+	}
+	return all
 }
 
 type MungeDef struct {
@@ -42,6 +331,77 @@ type MungeDef struct {
 	KeepDimension bool   `mapstructure:"keep_dim"`
 }
 
+// AddFieldOverrides will add the fields from the command line to the metrics. A field
+// at the same position as one in the config replaces it. The format is
+// [metric_name/][!]position[:label[:type]], without a metric name it applies to all of the
+// split and regex metrics.
+func (config *Config) AddFieldOverrides(raw []string, delim string) error {
+	for _, r := range raw {
+		target := ""
+		spec := r
+		if idx := strings.LastIndex(r, "/"); idx >= 0 {
+			target = r[:idx]
+			spec = r[idx+1:]
+			if target == "" {
+				return fmt.Errorf("missing the metric name in the field '%s'", r)
+			}
+		}
+
+		def, err := parsing.ExtractDefinition(spec, delim)
+		if err != nil {
+			return err
+		}
+		if !def.Type.Valid() {
+			return fmt.Errorf("unknown type '%s' in the field '%s'", def.Type, r)
+		}
+
+		found := false
+		for _, m := range config.allMetrics() {
+			if target != "" && m.Name != target {
+				continue
+			}
+			if !m.byPosition() {
+				if target != "" {
+					return fmt.Errorf("metric '%s' uses the %s format, its fields can't be set by position in '%s'", m.Name, m.Format, r)
+				}
+				// the position means nothing to them
+				continue
+			}
+			found = true
+			m.setField(*def)
+		}
+		if !found && target != "" {
+			return fmt.Errorf("there is no metric named '%s' for the field '%s'", target, r)
+		}
+		if !found {
+			return fmt.Errorf("there are no split or regex metrics for the field '%s'", r)
+		}
+	}
+
+	return nil
+}
+
+// byPosition is if the fields are selected by their position, json and logfmt
+// fields use a path or key instead
+func (m *MetricDef) byPosition() bool {
+	return m.Format != JSONFormat && m.Format != LogfmtFormat
+}
+
+func (m *MetricDef) setField(def parsing.FieldDef) {
+	for i, existing := range m.Fields {
+		if existing.Position == def.Position {
+			m.Fields[i] = def
+			return
+		}
+	}
+	m.Fields = append(m.Fields, def)
+}
+
+// ConfigFileUsed is the file that LoadConfig read, or "" if it didn't find one
+func ConfigFileUsed() string {
+	return viper.ConfigFileUsed()
+}
+
 // LoadConfig loads the config from a file if specified, otherwise from the environment
 func LoadConfig(cmd *cobra.Command) (*Config, error) {
 	viper.SetConfigType("json")
@@ -68,11 +428,35 @@ func LoadConfig(cmd *cobra.Command) (*Config, error) {
 		return nil, err
 	}
 
+	if used := viper.ConfigFileUsed(); used != "" {
+		if err := checkKeys(used); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
 	config := new(Config)
 
 	if err := viper.Unmarshal(config); err != nil {
 		return nil, err
 	}
 
+	// everything that is wrong is reported together so it can all be fixed at once
+	problems := []string{}
+	add := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for i := range config.Metrics {
+		add(config.Metrics[i].Compile())
+	}
+	for i := range config.Sources {
+		add(config.Sources[i].Compile())
+	}
+	if len(problems) > 0 {
+		add(config.Validate())
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+
 	return config, nil
 }
@@ -0,0 +1,125 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rybit/extractor/parsing"
+)
+
+func testConfig() *Config {
+	return &Config{
+		Metrics: []MetricDef{
+			{
+				Name: "ats.request_count",
+				Fields: []parsing.FieldDef{
+					{Position: 0},
+					{Position: 4, Label: "status"},
+				},
+			},
+			{
+				Name: "ats.request_dur",
+				Fields: []parsing.FieldDef{
+					{Position: 1},
+				},
+			},
+		},
+	}
+}
+
+func TestFieldOverridesForAll(t *testing.T) {
+	config := testConfig()
+	require.NoError(t, config.AddFieldOverrides([]string{"!8:content_type"}, ":"))
+
+	for _, m := range config.Metrics {
+		last := m.Fields[len(m.Fields)-1]
+		assert.Equal(t, 8, last.Position)
+		assert.Equal(t, "content_type", last.Label)
+		assert.Equal(t, ":", last.Delimiter)
+		assert.True(t, last.Required)
+	}
+	assert.Len(t, config.Metrics[0].Fields, 3)
+	assert.Len(t, config.Metrics[1].Fields, 2)
+}
+
+func TestFieldOverridesForMetric(t *testing.T) {
+	config := testConfig()
+	require.NoError(t, config.AddFieldOverrides([]string{"ats.request_count/4:code:number"}, "="))
+
+	// replaced the existing one
+	if assert.Len(t, config.Metrics[0].Fields, 2) {
+		assert.Equal(t, 4, config.Metrics[0].Fields[1].Position)
+		assert.Equal(t, "code", config.Metrics[0].Fields[1].Label)
+		assert.Equal(t, parsing.NumberType, config.Metrics[0].Fields[1].Type)
+	}
+	assert.Len(t, config.Metrics[1].Fields, 1)
+}
+
+func TestFieldOverridesSkipFormatsWithoutPositions(t *testing.T) {
+	config := testConfig()
+	config.Metrics = append(config.Metrics,
+		MetricDef{Name: "js", Format: JSONFormat, Fields: []parsing.FieldDef{{Path: "status"}}},
+		MetricDef{Name: "lf", Format: LogfmtFormat, Fields: []parsing.FieldDef{{Key: "level"}}},
+	)
+	require.NoError(t, config.AddFieldOverrides([]string{"1:code:number"}, "="))
+
+	assert.Len(t, config.Metrics[0].Fields, 3)
+	assert.Len(t, config.Metrics[1].Fields, 1)
+	assert.Len(t, config.Metrics[2].Fields, 1)
+	assert.Len(t, config.Metrics[3].Fields, 1)
+	assert.NoError(t, config.Validate())
+
+	err := config.AddFieldOverrides([]string{"js/1:code"}, "=")
+	if assert.Error(t, err) {
+		assert.Equal(t, "metric 'js' uses the json format, its fields can't be set by position in 'js/1:code'", err.Error())
+	}
+
+	onlyJSON := &Config{Metrics: config.Metrics[2:]}
+	assert.Error(t, onlyJSON.AddFieldOverrides([]string{"1:code"}, "="))
+}
+
+func TestFieldOverridesBad(t *testing.T) {
+	for _, raw := range []string{
+		"nonsense",
+		"-1:negative",
+		"2:label:nonsense",
+		"missing.metric/2:label",
+		"/2:label",
+	} {
+		assert.Error(t, testConfig().AddFieldOverrides([]string{raw}, "="), raw)
+	}
+}
+
+func TestSourceFor(t *testing.T) {
+	config := testConfig()
+	config.Sources = []SourceDef{
+		{Path: "/var/log/nginx/*.log", Metrics: []MetricDef{{Name: "nginx.requests"}}},
+		{Path: "/var/log/app.log", Metrics: []MetricDef{{Name: "app.errors"}}},
+	}
+	for i := range config.Sources {
+		require.NoError(t, config.Sources[i].Compile())
+	}
+
+	if s := config.SourceFor("/var/log/nginx/access.log"); assert.NotNil(t, s) {
+		assert.Equal(t, "nginx.requests", s.Metrics[0].Name)
+	}
+	if s := config.SourceFor("/var/log/./app.log"); assert.NotNil(t, s) {
+		assert.Equal(t, "app.errors", s.Metrics[0].Name)
+	}
+	assert.Nil(t, config.SourceFor("/var/log/other.log"))
+	assert.Equal(t, []string{"/var/log/nginx/*.log", "/var/log/app.log"}, config.SourcePaths())
+
+	// overrides reach the metrics in the sources too
+	require.NoError(t, config.AddFieldOverrides([]string{"app.errors/2:level"}, ":"))
+	if assert.Len(t, config.Sources[1].Metrics[0].Fields, 1) {
+		assert.Equal(t, "level", config.Sources[1].Metrics[0].Fields[0].Label)
+	}
+}
+
+func TestSourceCompile(t *testing.T) {
+	assert.Error(t, (&SourceDef{Metrics: []MetricDef{{Name: "no-path"}}}).Compile())
+	assert.Error(t, (&SourceDef{Path: "[", Metrics: []MetricDef{{Name: "bad-path"}}}).Compile())
+	assert.Error(t, (&SourceDef{Path: "/var/log/app.log"}).Compile())
+}
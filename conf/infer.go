@@ -0,0 +1,208 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rybit/extractor/parsing"
+)
+
+const defaultDelim = "="
+
+// the epoch timestamps that are believable, from 2000 until 2100
+const (
+	earliestEpochSec int64 = 946684800
+	latestEpochSec   int64 = 4102444800
+)
+
+// timestampLayouts are the go layouts that a single token could be in
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"02/Jan/2006:15:04:05",
+	"2006-01-02",
+}
+
+// the kinds a value can be, in the order they are preferred
+const (
+	numberKind = "number"
+	floatKind  = "float"
+	boolKind   = "bool"
+	urlKind    = "url"
+
+	timestampPrefix = "timestamp:"
+)
+
+// sample is everything seen at one position of the lines
+type sample struct {
+	seen  int
+	keys  map[string]int
+	order []string
+
+	// the kinds every value so far could be, nil until there is a value
+	kinds map[string]bool
+}
+
+// InferMetric builds a starter metric from sample lines. The lines are split the way
+// ParseLine does, and every position that has a key and a value becomes a field. The
+// type of the field is the narrowest one that every value parses as, and a field is
+// required if it is in every line. The first position that is always a timestamp is
+// used for the timestamp. It also returns notes about anything that needs a look.
+func InferMetric(name string, lines []string, delim string) (MetricDef, []string) {
+	if delim == "" {
+		delim = defaultDelim
+	}
+
+	m := MetricDef{Name: name}
+	notes := []string{}
+
+	samples := []*sample{}
+	count := 0
+	for _, line := range lines {
+		text := strings.TrimSpace(line)
+		if text == "" {
+			continue
+		}
+		count++
+
+		for pos, token := range strings.Split(text, " ") {
+			for len(samples) <= pos {
+				samples = append(samples, &sample{keys: make(map[string]int)})
+			}
+			key, val, ok := parsing.SplitField(token, delim)
+			if !ok {
+				continue
+			}
+			samples[pos].add(key, val)
+		}
+	}
+
+	labels := make(map[string]bool)
+	for pos, s := range samples {
+		if s.seen == 0 {
+			notes = append(notes, fmt.Sprintf("position %d never has the delimiter '%s', it can't be a field", pos, delim))
+			continue
+		}
+
+		def := parsing.FieldDef{
+			Position: pos,
+			Type:     parsing.StringType,
+			Required: s.seen == count,
+		}
+		if delim != defaultDelim {
+			def.Delimiter = delim
+		}
+
+		if len(s.order) == 1 {
+			def.Label = s.order[0]
+			if labels[def.Label] {
+				def.Label = fmt.Sprintf("%s_%d", def.Label, pos)
+			}
+			labels[def.Label] = true
+		} else {
+			// without a label it is whichever key the line has
+			for _, key := range s.order {
+				labels[key] = true
+			}
+			notes = append(notes, fmt.Sprintf("position %d has different keys: %s", pos, strings.Join(s.order, ", ")))
+		}
+
+		kind := s.kind(m.TimestampField == nil)
+		switch {
+		case strings.HasPrefix(kind, timestampPrefix):
+			// timestamps are parsed from the raw string
+			idx := pos
+			m.TimestampField = &idx
+			m.TimestampFormat = strings.TrimPrefix(kind, timestampPrefix)
+			notes = append(notes, fmt.Sprintf("position %d looks like a timestamp in the '%s' format", pos, m.TimestampFormat))
+		case kind != "":
+			def.Type = parsing.FieldType(kind)
+		}
+
+		m.Fields = append(m.Fields, def)
+	}
+
+	if count == 0 {
+		notes = append(notes, "there weren't any lines to look at")
+	}
+	return m, notes
+}
+
+func (s *sample) add(key, val string) {
+	s.seen++
+	if s.keys[key] == 0 {
+		s.order = append(s.order, key)
+	}
+	s.keys[key]++
+
+	kinds := kindsOf(val)
+	if s.kinds == nil {
+		s.kinds = kinds
+		return
+	}
+	for k := range s.kinds {
+		if !kinds[k] {
+			delete(s.kinds, k)
+		}
+	}
+}
+
+// kind picks the best kind for the position, or "" if it can only be a string
+func (s *sample) kind(allowTimestamp bool) string {
+	if allowTimestamp {
+		for _, format := range append([]string{"sec", "msec", "nano"}, timestampLayouts...) {
+			if s.kinds[timestampPrefix+format] {
+				return timestampPrefix + format
+			}
+		}
+	}
+
+	for _, k := range []string{numberKind, floatKind, boolKind, urlKind} {
+		if s.kinds[k] {
+			return k
+		}
+	}
+	return ""
+}
+
+// kindsOf is every kind that the value could be
+func kindsOf(val string) map[string]bool {
+	kinds := make(map[string]bool)
+	if _, err := strconv.Atoi(val); err == nil {
+		kinds[numberKind] = true
+	}
+	// ParseFloat takes "inf" and "nan" too
+	if _, err := strconv.ParseFloat(val, 64); err == nil && strings.ContainsAny(val, "0123456789") {
+		kinds[floatKind] = true
+	}
+	if _, err := strconv.ParseBool(val); err == nil && !kinds[numberKind] {
+		kinds[boolKind] = true
+	}
+	if u, err := url.Parse(val); err == nil && u.Scheme != "" && u.Host != "" {
+		kinds[urlKind] = true
+	}
+
+	if num, err := strconv.ParseInt(val, 10, 64); err == nil && !strings.HasPrefix(val, "-") {
+		switch {
+		case inEpoch(num, 1):
+			kinds[timestampPrefix+"sec"] = true
+		case inEpoch(num, 1000):
+			kinds[timestampPrefix+"msec"] = true
+		case inEpoch(num, int64(time.Second)):
+			kinds[timestampPrefix+"nano"] = true
+		}
+	}
+	for _, layout := range timestampLayouts {
+		if _, err := time.Parse(layout, val); err == nil {
+			kinds[timestampPrefix+layout] = true
+		}
+	}
+	return kinds
+}
+
+func inEpoch(num, perSec int64) bool {
+	return num >= earliestEpochSec*perSec && num < latestEpochSec*perSec
+}
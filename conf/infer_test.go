@@ -0,0 +1,62 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rybit/extractor/parsing"
+)
+
+func TestInferMetric(t *testing.T) {
+	lines := []string{
+		"ts=1480375544 dur=12 ratio=0.5 cached=true url=http://example.com/a method=GET",
+		"",
+		"ts=1480375545 dur=7 ratio=1 cached=false url=https://example.org method=POST extra=1",
+		"ts=1480375546 dur=3 ratio=2.25 cached=true url=http://example.com/b method=GET",
+	}
+
+	m, notes := InferMetric("requests", lines, "=")
+	require.NoError(t, m.Compile())
+	assert.NoError(t, m.Validate())
+
+	assert.Equal(t, "requests", m.Name)
+	if assert.NotNil(t, m.TimestampField) {
+		assert.Equal(t, 0, *m.TimestampField)
+	}
+	assert.Equal(t, "sec", m.TimestampFormat)
+	assert.Equal(t, []parsing.FieldDef{
+		{Position: 0, Label: "ts", Type: parsing.StringType, Required: true},
+		{Position: 1, Label: "dur", Type: parsing.NumberType, Required: true},
+		{Position: 2, Label: "ratio", Type: parsing.FloatType, Required: true},
+		{Position: 3, Label: "cached", Type: parsing.BoolType, Required: true},
+		{Position: 4, Label: "url", Type: parsing.URLType, Required: true},
+		{Position: 5, Label: "method", Type: parsing.StringType, Required: true},
+		{Position: 6, Label: "extra", Type: parsing.NumberType},
+	}, m.Fields)
+	assert.Equal(t, []string{"position 0 looks like a timestamp in the 'sec' format"}, notes)
+}
+
+func TestInferMetricOddPositions(t *testing.T) {
+	lines := []string{
+		"GET at:2017-03-14T09:26:53Z a:1 a:x",
+		"PUT at:2017-03-14T09:27:01Z b:2 a:y",
+	}
+
+	m, notes := InferMetric("odd", lines, ":")
+	require.NoError(t, m.Compile())
+	assert.NoError(t, m.Validate())
+
+	assert.Equal(t, "2006-01-02T15:04:05Z07:00", m.TimestampFormat)
+	assert.Equal(t, []parsing.FieldDef{
+		{Position: 1, Label: "at", Type: parsing.StringType, Delimiter: ":", Required: true},
+		{Position: 2, Type: parsing.NumberType, Delimiter: ":", Required: true},
+		{Position: 3, Label: "a_3", Type: parsing.StringType, Delimiter: ":", Required: true},
+	}, m.Fields)
+	assert.Equal(t, []string{
+		"position 0 never has the delimiter ':', it can't be a field",
+		"position 1 looks like a timestamp in the '2006-01-02T15:04:05Z07:00' format",
+		"position 2 has different keys: a, b",
+	}, notes)
+}
@@ -0,0 +1,224 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/rybit/extractor/checkpoint"
+	"github.com/rybit/extractor/parsing"
+)
+
+// checkKeys will decode the file on its own and fail on any keys that don't belong to
+// the config. The global viper can't be used because it has the command line flags too.
+func checkKeys(path string) error {
+	v := viper.New()
+	v.SetConfigType("json")
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	return v.UnmarshalExact(new(Config))
+}
+
+// Validate will check everything that can be checked before reading any lines. It
+// reports all the problems it finds, one per line. The metrics must be compiled first,
+// the ones that failed to compile are skipped.
+func (config *Config) Validate() error {
+	problems := []string{}
+	add := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if !config.HasMetrics() {
+		add(errors.New("there must be at least one metric"))
+	}
+	for i := range config.Metrics {
+		if !config.Metrics[i].failed {
+			add(config.Metrics[i].Validate())
+		}
+	}
+	for _, s := range config.Sources {
+		for i := range s.Metrics {
+			if !s.Metrics[i].failed {
+				add(s.Metrics[i].Validate())
+			}
+		}
+	}
+
+	for _, name := range config.Sinks {
+		switch name {
+		case NatsSink, LogSink, PrometheusSink, StatsdSink, InfluxSink:
+		default:
+			add(fmt.Errorf("unknown sink '%s'", name))
+		}
+	}
+
+	if config.CheckpointConf != nil {
+		if config.CheckpointConf.File == "" {
+			add(errors.New("checkpoint_conf must have a file to store the checkpoints in"))
+		}
+		switch config.CheckpointConf.Fallback {
+		case "", checkpoint.FallbackResume, checkpoint.FallbackStart, checkpoint.FallbackEnd:
+		default:
+			add(fmt.Errorf("unknown checkpoint fallback '%s'", config.CheckpointConf.Fallback))
+		}
+	}
+
+	switch config.LongLines {
+	case "", TruncateLongLines, SkipLongLines:
+	default:
+		add(fmt.Errorf("unknown way to handle long lines '%s'", config.LongLines))
+	}
+	if config.MaxLineLength < 0 {
+		add(errors.New("max_line_length can't be negative"))
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Validate will check the fields of the metric against each other. The indexes for the
+// value, timestamp, name and conditions must point at a field, and the labels must be unique.
+func (m *MetricDef) Validate() error {
+	if strings.TrimSpace(m.Name) == "" {
+		return errors.New("a metric must have a name")
+	}
+	if m.Format == RegexFormat && m.regex == nil {
+		return fmt.Errorf("metric '%s': the pattern hasn't been compiled", m.Name)
+	}
+
+	problems := []string{}
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("metric '%s': ", m.Name)+fmt.Sprintf(format, args...))
+	}
+
+	keys := make(map[int]bool)
+	labels := make(map[string]int)
+	for i, f := range m.Fields {
+		if !f.Type.Valid() {
+			add("field %d has an unknown type '%s'", i, f.Type)
+		}
+		if f.Position < 0 {
+			add("field %d has a negative position", i)
+		}
+
+		key, label := m.fieldKey(i, f)
+		switch m.Format {
+		case JSONFormat:
+			if f.Path == "" {
+				add("field %d must have a path", i)
+			}
+		case LogfmtFormat:
+			if f.Key == "" {
+				add("field %d must have a key", i)
+			}
+		case RegexFormat:
+			if key < 0 {
+				add("field %d uses the capture group '%s' which isn't in the pattern", i, f.Group)
+			} else if key >= len(m.regex.SubexpNames()) {
+				add("field %d uses capture group %d but the pattern only has %d", i, key, len(m.regex.SubexpNames())-1)
+			}
+		}
+
+		if keys[key] {
+			add("field %d is at the same index as another field, %d", i, key)
+		}
+		keys[key] = true
+
+		if label != "" {
+			if other, exists := labels[label]; exists {
+				add("fields %d and %d both have the label '%s'", other, i, label)
+			}
+			labels[label] = i
+		}
+	}
+
+	if m.ValueField != nil && !keys[*m.ValueField] {
+		add("value_index %d doesn't point at a field", *m.ValueField)
+	}
+	if m.TimestampField != nil && !keys[*m.TimestampField] {
+		add("timestamp_index %d doesn't point at a field", *m.TimestampField)
+	}
+	if m.MungeDef != nil && !keys[m.MungeDef.FieldNumber] {
+		add("munge_def.field_index %d doesn't point at a field", m.MungeDef.FieldNumber)
+	}
+
+	if m.Match != nil {
+		for _, problem := range m.Match.validate("match", keys) {
+			add("%s", problem)
+		}
+	}
+	if m.Drop != nil {
+		for _, problem := range m.Drop.validate("drop", keys) {
+			add("%s", problem)
+		}
+	}
+
+	if err := validTimestampFormat(m.TimestampFormat); err != nil {
+		add("%v", err)
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// fieldKey is the index of the field in the parsed fields and the label it will have,
+// if that is known before parsing a line
+func (m *MetricDef) fieldKey(i int, f parsing.FieldDef) (int, string) {
+	label := f.Label
+	switch m.Format {
+	case JSONFormat:
+		if label == "" {
+			label = f.Path
+		}
+		return i, label
+	case LogfmtFormat:
+		if label == "" {
+			label = f.Key
+		}
+		return i, label
+	case RegexFormat:
+		if f.Group == "" {
+			return f.Position, label
+		}
+		if label == "" {
+			label = f.Group
+		}
+		for idx, name := range m.regex.SubexpNames() {
+			if name == f.Group {
+				return idx, label
+			}
+		}
+		return -1, label
+	}
+	// split lines use the key in the token when there isn't a label
+	return f.Position, label
+}
+
+func validTimestampFormat(format string) error {
+	switch format {
+	case "", "msec", "nano", "sec":
+		return nil
+	}
+
+	// a layout without any of the reference values formats to itself
+	sample := time.Date(2017, time.March, 14, 9, 26, 53, 0, time.UTC)
+	formatted := sample.Format(format)
+	if formatted == format {
+		return fmt.Errorf("timestamp_format '%s' isn't 'msec', 'nano', 'sec' or a go time layout", format)
+	}
+	if _, err := time.Parse(format, formatted); err != nil {
+		return fmt.Errorf("timestamp_format '%s' can't be used to parse: %v", format, err)
+	}
+	return nil
+}
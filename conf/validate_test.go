@@ -0,0 +1,145 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rybit/extractor/parsing"
+)
+
+func TestValidateMetric(t *testing.T) {
+	one, five := 1, 5
+	tests := map[string]struct {
+		m        MetricDef
+		expected string
+	}{
+		"valid": {
+			m: MetricDef{Name: "valid", Fields: []parsing.FieldDef{{Position: 0}, {Position: 1, Label: "dur"}}, ValueField: &one, TimestampFormat: "2006-01-02T15:04:05"},
+		},
+		"no name": {
+			m:        MetricDef{Name: " "},
+			expected: "a metric must have a name",
+		},
+		"unknown type": {
+			m:        MetricDef{Name: "bad-type", Fields: []parsing.FieldDef{{Position: 0, Type: "nonsense"}}},
+			expected: "metric 'bad-type': field 0 has an unknown type 'nonsense'",
+		},
+		"dangling value": {
+			m:        MetricDef{Name: "dangling", Fields: []parsing.FieldDef{{Position: 0}}, ValueField: &five},
+			expected: "metric 'dangling': value_index 5 doesn't point at a field",
+		},
+		"dangling timestamp": {
+			m:        MetricDef{Name: "dangling", Fields: []parsing.FieldDef{{Position: 0}}, TimestampField: &one},
+			expected: "metric 'dangling': timestamp_index 1 doesn't point at a field",
+		},
+		"dangling munge": {
+			m:        MetricDef{Name: "dangling", Fields: []parsing.FieldDef{{Position: 0}}, MungeDef: &MungeDef{FieldNumber: 3}},
+			expected: "metric 'dangling': munge_def.field_index 3 doesn't point at a field",
+		},
+		"duplicate labels": {
+			m:        MetricDef{Name: "dupes", Fields: []parsing.FieldDef{{Position: 0, Label: "status"}, {Position: 1, Label: "status"}}},
+			expected: "metric 'dupes': fields 0 and 1 both have the label 'status'",
+		},
+		"duplicate json paths": {
+			m:        MetricDef{Name: "dupes", Format: JSONFormat, Fields: []parsing.FieldDef{{Path: "a.b"}, {Path: "a.b"}}},
+			expected: "metric 'dupes': fields 0 and 1 both have the label 'a.b'",
+		},
+		"missing key": {
+			m:        MetricDef{Name: "no-key", Format: LogfmtFormat, Fields: []parsing.FieldDef{{Label: "level"}}},
+			expected: "metric 'no-key': field 0 must have a key",
+		},
+		"missing group": {
+			m:        MetricDef{Name: "no-group", Format: RegexFormat, Pattern: `(?P<status>\d+)`, Fields: []parsing.FieldDef{{Group: "code"}}},
+			expected: "metric 'no-group': field 0 uses the capture group 'code' which isn't in the pattern",
+		},
+		"named group value": {
+			m: MetricDef{Name: "group-value", Format: RegexFormat, Pattern: `(\w+) (?P<dur>\d+)`, Fields: []parsing.FieldDef{{Group: "dur"}}, ValueField: &[]int{2}[0]},
+		},
+		"bad timestamp format": {
+			m:        MetricDef{Name: "bad-time", TimestampFormat: "seconds"},
+			expected: "metric 'bad-time': timestamp_format 'seconds' isn't 'msec', 'nano', 'sec' or a go time layout",
+		},
+	}
+
+	for name, test := range tests {
+		require.NoError(t, test.m.Compile(), name)
+		err := test.m.Validate()
+		if test.expected == "" {
+			assert.NoError(t, err, name)
+		} else if assert.Error(t, err, name) {
+			assert.Equal(t, test.expected, err.Error(), name)
+		}
+	}
+}
+
+func TestValidateReportsEverything(t *testing.T) {
+	config := testConfig()
+	config.Metrics = append(config.Metrics, MetricDef{Name: ""})
+	config.Sinks = []string{"carrier-pigeon"}
+	config.LongLines = "fold"
+
+	err := config.Validate()
+	if assert.Error(t, err) {
+		assert.Equal(t, "a metric must have a name\nunknown sink 'carrier-pigeon'\nunknown way to handle long lines 'fold'", err.Error())
+	}
+
+	assert.NoError(t, testConfig().Validate())
+}
+
+func TestLoadConfigReportsEverything(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing-config")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer viper.Reset()
+
+	f.WriteString(`{
+		"metrics": [
+			{"name": "bad-kind", "kind": "dial"},
+			{"name": "bad-pattern", "format": "regex", "pattern": "("},
+			{"name": "bad-index", "value_index": 3}
+		],
+		"sources": [{"path": "/var/log/app.log", "metrics": [{"name": "bad-format", "format": "xml"}]}],
+		"sinks": ["carrier-pigeon"]
+	}`)
+	f.Close()
+
+	cmd := new(cobra.Command)
+	cmd.Flags().String("config", f.Name(), "")
+	_, err = LoadConfig(cmd)
+	if assert.Error(t, err) {
+		problems := strings.Split(err.Error(), "\n")
+		require.Len(t, problems, 5, err.Error())
+		assert.Contains(t, problems[0], "metric 'bad-kind' has an unknown kind 'dial'")
+		assert.Contains(t, problems[1], "metric 'bad-pattern' has an invalid pattern")
+		assert.Contains(t, problems[2], "metric 'bad-format' has an unknown format 'xml'")
+		assert.Contains(t, problems[3], "metric 'bad-index'")
+		assert.Equal(t, "unknown sink 'carrier-pigeon'", problems[4])
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing-config")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{
+		"metrics": [{"name": "ok", "fields": [{"position": 0, "delimiter": ":"}]}],
+		"stats_conf": {"report_sec": 10, "prefix": "extractor"}
+	}`)
+	f.Close()
+
+	err = checkKeys(f.Name())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "delimiter")
+	}
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte(`{"metrics": [{"name": "ok", "fields": [{"position": 0, "delim": ":"}]}]}`), 0644))
+	assert.NoError(t, checkKeys(f.Name()))
+}
@@ -0,0 +1,108 @@
+package parsing
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ParseJSONLine will decode the line as a JSON object and pull each field out by its
+// dotted path (e.g. 'request.status' or 'hops.0.host'). The fields are keyed by their
+// index in the list of fields.
+func ParseJSONLine(raw string, fields []FieldDef, log *logrus.Entry) (map[int]ParsedField, map[string]interface{}, bool) {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+
+	var obj interface{}
+	if err := dec.Decode(&obj); err != nil {
+		log.WithError(err).Warn("Failed to decode the line as JSON")
+		return nil, nil, false
+	}
+
+	parsed := make(map[int]ParsedField)
+	extra := make(map[string]interface{})
+	for i, def := range fields {
+		required := def.Required
+		found, ok := lookup(obj, def.Path)
+		if !ok || found == nil {
+			if required {
+				log.Warnf("Missing required field at path '%s'", def.Path)
+				return nil, nil, false
+			}
+			continue
+		}
+
+		rawVal, err := scalar(found)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to use the value at path '%s'", def.Path)
+			if required {
+				return nil, nil, false
+			}
+			continue
+		}
+
+		val, err := parseValue(def.Type, rawVal, extra, log)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to convert '%s' to a %s", rawVal, def.Type)
+			if required {
+				return nil, nil, false
+			}
+		}
+
+		label := def.Path
+		if def.Label != "" {
+			label = def.Label
+		}
+
+		parsed[i] = ParsedField{
+			Value: val,
+			Label: label,
+		}
+	}
+
+	return parsed, extra, true
+}
+
+func lookup(obj interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+
+	current := obj
+	for _, part := range strings.Split(path, ".") {
+		switch typed := current.(type) {
+		case map[string]interface{}:
+			next, ok := typed[part]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(typed) {
+				return nil, false
+			}
+			current = typed[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func scalar(val interface{}) (string, error) {
+	switch typed := val.(type) {
+	case string:
+		return typed, nil
+	case json.Number:
+		return typed.String(), nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	}
+
+	return "", fmt.Errorf("expected a string, number or bool but found %T", val)
+}
@@ -0,0 +1,58 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonLine = `{"level":"info","request":{"status":503,"duration":0.25,"url":"https://gotham.com/villians","cached":false},"hops":[{"host":"edge-1"},{"host":"origin"}]}`
+
+func TestParseJSONLine(t *testing.T) {
+	fields := []FieldDef{
+		{Path: "level"},
+		{Path: "request.status", Type: NumberType, Label: "status"},
+		{Path: "request.duration", Type: FloatType},
+		{Path: "request.cached", Type: BoolType},
+		{Path: "request.url", Type: URLType, Label: "domain"},
+		{Path: "hops.1.host", Label: "last_hop"},
+		{Path: "not.there"},
+	}
+
+	if parsed, dims, ok := ParseJSONLine(jsonLine, fields, tl); assert.True(t, ok) {
+		assert.Len(t, parsed, 6)
+		validateFields(t, parsed[0], "info", "level")
+		validateFields(t, parsed[1], 503, "status")
+		validateFields(t, parsed[2], 0.25, "request.duration")
+		validateFields(t, parsed[3], false, "request.cached")
+		validateFields(t, parsed[4], "gotham.com", "domain")
+		validateFields(t, parsed[5], "origin", "last_hop")
+		assert.Len(t, dims, 1)
+		assert.EqualValues(t, "https", dims["scheme"])
+	}
+}
+
+func TestParseJSONLineMissingRequired(t *testing.T) {
+	fields := []FieldDef{{Path: "request.nothing", Required: true}}
+	_, _, ok := ParseJSONLine(jsonLine, fields, tl)
+	assert.False(t, ok)
+
+	fields = []FieldDef{{Path: "hops.5.host", Required: true}}
+	_, _, ok = ParseJSONLine(jsonLine, fields, tl)
+	assert.False(t, ok)
+
+	// objects aren't values
+	fields = []FieldDef{{Path: "request", Required: true}}
+	_, _, ok = ParseJSONLine(jsonLine, fields, tl)
+	assert.False(t, ok)
+
+	fields = []FieldDef{{Path: "level", Type: NumberType, Required: true}}
+	_, _, ok = ParseJSONLine(jsonLine, fields, tl)
+	assert.False(t, ok)
+}
+
+func TestParseJSONLineNotJSON(t *testing.T) {
+	fields := []FieldDef{{Path: "level"}}
+	_, _, ok := ParseJSONLine("nothing=else enter=sandman", fields, tl)
+	assert.False(t, ok)
+}
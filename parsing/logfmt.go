@@ -0,0 +1,127 @@
+package parsing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ParseLogfmtLine will break the line up into logfmt key/value pairs and pick
+// each field out by its key, so the order of the pairs doesn't matter. The fields
+// are keyed by their index in the list of fields.
+func ParseLogfmtLine(raw string, fields []FieldDef, log *logrus.Entry) (map[int]ParsedField, map[string]interface{}, bool) {
+	pairs, err := splitLogfmt(raw)
+	if err != nil {
+		log.WithError(err).Warn("Failed to parse the line as logfmt")
+		return nil, nil, false
+	}
+
+	parsed := make(map[int]ParsedField)
+	extra := make(map[string]interface{})
+	for i, def := range fields {
+		required := def.Required
+		rawVal, ok := pairs[def.Key]
+		if !ok {
+			if required {
+				log.Warnf("Missing required field with key '%s'", def.Key)
+				return nil, nil, false
+			}
+			continue
+		}
+
+		val, err := parseValue(def.Type, rawVal, extra, log)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to convert '%s' to a %s", rawVal, def.Type)
+			if required {
+				return nil, nil, false
+			}
+		}
+
+		label := def.Key
+		if def.Label != "" {
+			label = def.Label
+		}
+
+		parsed[i] = ParsedField{
+			Value: val,
+			Label: label,
+		}
+	}
+
+	return parsed, extra, true
+}
+
+func splitLogfmt(raw string) (map[string]string, error) {
+	pairs := make(map[string]string)
+
+	// the simple case, nothing is quoted so we can just split it up
+	if !strings.Contains(raw, `"`) {
+		for _, part := range strings.Split(raw, " ") {
+			if part == "" {
+				continue
+			}
+			if key, val, ok := SplitField(part, "="); ok {
+				pairs[key] = val
+			} else {
+				pairs[part] = ""
+			}
+		}
+		return pairs, nil
+	}
+
+	i := 0
+	for i < len(raw) {
+		if raw[i] == ' ' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(raw) && raw[i] != '=' && raw[i] != ' ' {
+			i++
+		}
+		key := raw[start:i]
+		if key == "" || strings.Contains(key, `"`) {
+			return nil, fmt.Errorf("invalid key at offset %d", start)
+		}
+
+		if i >= len(raw) || raw[i] == ' ' {
+			// a bare key
+			pairs[key] = ""
+			continue
+		}
+
+		// skip the '='
+		i++
+		if i < len(raw) && raw[i] == '"' {
+			end := i + 1
+			for end < len(raw) && raw[end] != '"' {
+				if raw[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(raw) {
+				return nil, fmt.Errorf("unterminated quote for key '%s'", key)
+			}
+
+			val, err := strconv.Unquote(raw[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value for key '%s': %v", key, err)
+			}
+			pairs[key] = val
+			i = end + 1
+			continue
+		}
+
+		start = i
+		for i < len(raw) && raw[i] != ' ' {
+			i++
+		}
+		pairs[key] = raw[start:i]
+	}
+
+	return pairs, nil
+}
@@ -0,0 +1,66 @@
+package parsing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogfmtLine(t *testing.T) {
+	fields := []FieldDef{
+		{Key: "level"},
+		{Key: "msg", Label: "message"},
+		{Key: "status", Type: NumberType},
+		{Key: "dur", Type: FloatType},
+		{Key: "missing"},
+	}
+
+	raw := `time="2017-01-30T16:28:18-08:00" level=warning msg="Failed to \"connect\" to gotham" status=503 dur=0.25`
+	if parsed, dims, ok := ParseLogfmtLine(raw, fields, tl); assert.True(t, ok) {
+		assert.Len(t, dims, 0)
+		assert.Len(t, parsed, 4)
+		validateFields(t, parsed[0], "warning", "level")
+		validateFields(t, parsed[1], `Failed to "connect" to gotham`, "message")
+		validateFields(t, parsed[2], 503, "status")
+		validateFields(t, parsed[3], 0.25, "dur")
+	}
+
+	// the order shouldn't matter
+	raw = `dur=0.5 status=200 level=info msg=hello`
+	if parsed, _, ok := ParseLogfmtLine(raw, fields, tl); assert.True(t, ok) {
+		assert.Len(t, parsed, 4)
+		validateFields(t, parsed[0], "info", "level")
+		validateFields(t, parsed[1], "hello", "message")
+		validateFields(t, parsed[2], 200, "status")
+	}
+}
+
+func TestParseLogfmtLineMissingRequired(t *testing.T) {
+	fields := []FieldDef{{Key: "status", Required: true}}
+	_, _, ok := ParseLogfmtLine(`level=info msg="no status here"`, fields, tl)
+	assert.False(t, ok)
+
+	fields = []FieldDef{{Key: "status", Type: NumberType, Required: true}}
+	_, _, ok = ParseLogfmtLine(`status=bad`, fields, tl)
+	assert.False(t, ok)
+}
+
+func TestSplitLogfmt(t *testing.T) {
+	pairs, err := splitLogfmt(`a=1  b="two words" c="" flag d=x\y`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"a":    "1",
+		"b":    "two words",
+		"c":    "",
+		"flag": "",
+		"d":    `x\y`,
+	}, pairs)
+
+	pairs, err = splitLogfmt(`a=1 flag b=2`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "flag": "", "b": "2"}, pairs)
+
+	_, err = splitLogfmt(`a="unterminated`)
+	assert.Error(t, err)
+}
@@ -1,7 +1,9 @@
 package parsing
 
 import (
+	"fmt"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -21,9 +23,18 @@ const (
 )
 
 type FieldDef struct {
-	// required
+	// required, unless the field is selected some other way
 	Position int `mapstructure:"position"`
 
+	// optional for regex formats, the name of the capture group to use instead of the position
+	Group string `mapstructure:"group"`
+
+	// required for json formats, the dotted path to the value
+	Path string `mapstructure:"path"`
+
+	// required for logfmt formats, the key of the value
+	Key string `mapstructure:"key"`
+
 	// optional
 	Type      FieldType `mapstructure:"type"`
 	Label     string    `mapstructure:"label"`
@@ -42,33 +53,32 @@ type ParsedLine struct {
 	Dims      map[string]interface{}
 }
 
-func ExtractDefinition(raw, delim string, log *logrus.Entry) *FieldDef {
+// ExtractDefinition parses a field from the command line, the format is [!]position[:label[:type]]
+func ExtractDefinition(raw, delim string) (*FieldDef, error) {
 	def := &FieldDef{
 		Type:      StringType,
 		Delimiter: delim,
 	}
 
-	if strings.HasPrefix(raw, "!") {
+	spec := raw
+	if strings.HasPrefix(spec, "!") {
 		def.Required = true
-		raw = raw[1:]
+		spec = spec[1:]
 	}
 
-	parts := strings.Split(raw, ":")
-	if len(parts) < 1 {
-		log.Warnf("Failed to parse '%s'. The format is [!]position:label[:type]", raw)
-		return nil
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("failed to parse '%s', the format is [!]position[:label[:type]]", raw)
 	}
 	for i, part := range parts {
 		switch i {
 		case 0:
 			pos, err := strconv.Atoi(part)
 			if err != nil {
-				log.WithError(err).Warnf("Failed to parse '%s' into an int for the position.", part)
-				return nil
+				return nil, fmt.Errorf("failed to parse '%s' into an int for the position in '%s'", part, raw)
 			}
 			if pos < 0 {
-				log.Warnf("Can't have a negative position")
-				return nil
+				return nil, fmt.Errorf("can't have a negative position in '%s'", raw)
 			}
 			def.Position = pos
 		case 1:
@@ -78,10 +88,20 @@ func ExtractDefinition(raw, delim string, log *logrus.Entry) *FieldDef {
 		}
 	}
 
-	return def
+	return def, nil
 }
 
-func split(raw, delim string) (string, string, bool) {
+// Valid checks that the type is one that is supported
+func (t FieldType) Valid() bool {
+	switch t {
+	case StringType, NumberType, FloatType, BoolType, URLType, FieldType(""):
+		return true
+	}
+	return false
+}
+
+// SplitField breaks a token into its key and value on the first delimiter, the default is "="
+func SplitField(raw, delim string) (string, string, bool) {
 	if delim == "" {
 		delim = "="
 	}
@@ -108,7 +128,7 @@ func ParseLine(raw string, fields []FieldDef, log *logrus.Entry) (map[int]Parsed
 			continue
 		}
 
-		key, rawVal, ok := split(parts[def.Position], def.Delimiter)
+		key, rawVal, ok := SplitField(parts[def.Position], def.Delimiter)
 		if !ok {
 			log.Warnf("Failed to split the field '%s' using delimiter '%s'", parts[def.Position], def.Delimiter)
 			if required {
@@ -117,28 +137,7 @@ func ParseLine(raw string, fields []FieldDef, log *logrus.Entry) (map[int]Parsed
 			continue
 		}
 
-		// parse the value component
-		var val interface{}
-		var err error
-		switch def.Type {
-		case NumberType:
-			val, err = strconv.Atoi(rawVal)
-		case FloatType:
-			val, err = strconv.ParseFloat(rawVal, 64)
-		case BoolType:
-			val, err = strconv.ParseBool(rawVal)
-		case StringType, FieldType(""):
-			val = rawVal
-		case URLType:
-			var scheme, url string
-			scheme, url, err = extractDomain(rawVal)
-			extra["scheme"] = scheme
-			val = url
-		default:
-			val = rawVal
-			log.Warnf("Unknown field type '%s' treating it as a string", def.Type)
-		}
-
+		val, err := parseValue(def.Type, rawVal, extra, log)
 		if err != nil {
 			log.WithError(err).Warnf("Failed to convert '%s' to a %s", rawVal, def.Type)
 			if required {
@@ -159,6 +158,109 @@ func ParseLine(raw string, fields []FieldDef, log *logrus.Entry) (map[int]Parsed
 	return parsed, extra, true
 }
 
+// ParseRegexLine will match the line against the pattern and build the fields from
+// the capture groups. The fields are keyed by the group's number, a field can select
+// the group either by its number in the position or by the group's name.
+func ParseRegexLine(raw string, re *regexp.Regexp, fields []FieldDef, log *logrus.Entry) (map[int]ParsedField, map[string]interface{}, bool) {
+	matches := re.FindStringSubmatchIndex(raw)
+	if matches == nil {
+		log.Debugf("Line doesn't match the pattern '%s'", re.String())
+		return nil, nil, false
+	}
+
+	parsed := make(map[int]ParsedField)
+	extra := make(map[string]interface{})
+	names := re.SubexpNames()
+	for _, def := range fields {
+		required := def.Required
+		idx := def.Position
+		if def.Group != "" {
+			idx = groupIndex(names, def.Group)
+			if idx < 0 {
+				log.Warnf("There is no capture group named '%s' in the pattern", def.Group)
+				if required {
+					return nil, nil, false
+				}
+				continue
+			}
+		}
+
+		if idx >= len(names) {
+			if required {
+				log.Warnf("Missing required capture group %d, there are only %d groups", idx, len(names)-1)
+				return nil, nil, false
+			}
+			continue
+		}
+
+		if matches[2*idx] < 0 {
+			if required {
+				log.Warnf("Required capture group %d didn't match anything", idx)
+				return nil, nil, false
+			}
+			continue
+		}
+
+		rawVal := raw[matches[2*idx]:matches[2*idx+1]]
+		val, err := parseValue(def.Type, rawVal, extra, log)
+		if err != nil {
+			log.WithError(err).Warnf("Failed to convert '%s' to a %s", rawVal, def.Type)
+			if required {
+				return nil, nil, false
+			}
+		}
+
+		label := def.Label
+		if label == "" {
+			label = names[idx]
+		}
+		if label == "" {
+			label = strconv.Itoa(idx)
+		}
+
+		parsed[idx] = ParsedField{
+			Value: val,
+			Label: label,
+		}
+	}
+
+	return parsed, extra, true
+}
+
+func groupIndex(names []string, group string) int {
+	for i, name := range names {
+		if i > 0 && name == group {
+			return i
+		}
+	}
+	return -1
+}
+
+func parseValue(fieldType FieldType, rawVal string, extra map[string]interface{}, log *logrus.Entry) (interface{}, error) {
+	var val interface{}
+	var err error
+	switch fieldType {
+	case NumberType:
+		val, err = strconv.Atoi(rawVal)
+	case FloatType:
+		val, err = strconv.ParseFloat(rawVal, 64)
+	case BoolType:
+		val, err = strconv.ParseBool(rawVal)
+	case StringType, FieldType(""):
+		val = rawVal
+	case URLType:
+		var scheme, url string
+		scheme, url, err = extractDomain(rawVal)
+		extra["scheme"] = scheme
+		val = url
+	default:
+		val = rawVal
+		log.Warnf("Unknown field type '%s' treating it as a string", fieldType)
+	}
+
+	return val, err
+}
+
 func extractDomain(rawURL string) (string, string, error) {
 	url, err := url.Parse(rawURL)
 	if err != nil {
@@ -1,6 +1,7 @@
 package parsing
 
 import (
+	"regexp"
 	"testing"
 
 	"github.com/Sirupsen/logrus"
@@ -10,32 +11,45 @@ import (
 var tl = logrus.NewEntry(logrus.StandardLogger())
 
 func TestCmdLineParseGood(t *testing.T) {
-	if def := ExtractDefinition("!1:hp:bool", "=", tl); assert.NotNil(t, def) {
+	if def, err := ExtractDefinition("!1:hp:bool", "="); assert.NoError(t, err) {
 		validate(t, def, true, 1, "hp", BoolType, "=")
 	}
 
-	if def := ExtractDefinition("12:mp", "=", tl); assert.NotNil(t, def) {
+	if def, err := ExtractDefinition("12:mp", "="); assert.NoError(t, err) {
 		validate(t, def, false, 12, "mp", StringType, "=")
 	}
 
-	if def := ExtractDefinition("123:xp:nonsense", "=", tl); assert.NotNil(t, def) {
+	if def, err := ExtractDefinition("123:xp:nonsense", "="); assert.NoError(t, err) {
 		validate(t, def, false, 123, "xp", FieldType("nonsense"), "=")
 	}
 
-	if def := ExtractDefinition("1", "=", tl); assert.NotNil(t, def) {
+	if def, err := ExtractDefinition("1", "="); assert.NoError(t, err) {
 		validate(t, def, false, 1, "", StringType, "=")
 	}
 }
 
 func TestCmdLineExtractBad(t *testing.T) {
-	def := ExtractDefinition("nonsense", "=", tl)
+	def, err := ExtractDefinition("nonsense", "=")
+	assert.Error(t, err)
 	assert.Nil(t, def)
 
-	def = ExtractDefinition("d:should-be-a-number", "=", tl)
+	def, err = ExtractDefinition("d:should-be-a-number", "=")
+	assert.Error(t, err)
 	assert.Nil(t, def)
 
-	def = ExtractDefinition("-2:should-be-positive", "=", tl)
+	def, err = ExtractDefinition("-2:should-be-positive", "=")
+	assert.Error(t, err)
 	assert.Nil(t, def)
+
+	def, err = ExtractDefinition("1:too:many:parts", "=")
+	assert.Error(t, err)
+	assert.Nil(t, def)
+}
+
+func TestFieldTypeValid(t *testing.T) {
+	assert.True(t, NumberType.Valid())
+	assert.True(t, FieldType("").Valid())
+	assert.False(t, FieldType("nonsense").Valid())
 }
 
 func TestParseLineNiceLine(t *testing.T) {
@@ -155,6 +169,54 @@ func TestParseURLLine(t *testing.T) {
 	}
 }
 
+var combinedLog = regexp.MustCompile(`^(?P<ip>\S+) \S+ \S+ \[(?P<when>[^\]]+)\] "(?P<method>\S+) (?P<path>\S+) \S+" (?P<status>\d+) (?P<size>\d+) "[^"]*" "(?P<agent>[^"]*)"$`)
+
+const combinedLine = `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+
+func TestParseRegexLine(t *testing.T) {
+	fields := []FieldDef{
+		{Group: "method"},
+		{Group: "status", Type: NumberType, Required: true},
+		{Group: "agent", Label: "user_agent"},
+		{Position: 6, Label: "bytes", Type: NumberType},
+	}
+
+	if parsed, dims, ok := ParseRegexLine(combinedLine, combinedLog, fields, tl); assert.True(t, ok) {
+		assert.Len(t, dims, 0)
+		assert.Len(t, parsed, 4)
+		validateFields(t, parsed[3], "GET", "method")
+		validateFields(t, parsed[5], 200, "status")
+		validateFields(t, parsed[6], 2326, "bytes")
+		validateFields(t, parsed[7], "Mozilla/4.08 [en] (Win98; I ;Nav)", "user_agent")
+	}
+}
+
+func TestParseRegexLineNoMatch(t *testing.T) {
+	fields := []FieldDef{{Group: "method"}}
+	_, _, ok := ParseRegexLine("nothing=else enter=sandman", combinedLog, fields, tl)
+	assert.False(t, ok)
+}
+
+func TestParseRegexLineMissingRequired(t *testing.T) {
+	re := regexp.MustCompile(`^(?P<key>\w+)(=(?P<value>\w+))?$`)
+	fields := []FieldDef{{Group: "value"}}
+	if parsed, _, ok := ParseRegexLine("marp", re, fields, tl); assert.True(t, ok) {
+		assert.Len(t, parsed, 0)
+	}
+
+	fields = []FieldDef{{Group: "value", Required: true}}
+	_, _, ok := ParseRegexLine("marp", re, fields, tl)
+	assert.False(t, ok)
+
+	fields = []FieldDef{{Group: "nonsense", Required: true}}
+	_, _, ok = ParseRegexLine("marp=123", re, fields, tl)
+	assert.False(t, ok)
+
+	fields = []FieldDef{{Group: "value", Type: NumberType, Required: true}}
+	_, _, ok = ParseRegexLine("marp=notanumber", re, fields, tl)
+	assert.False(t, ok)
+}
+
 func validate(t *testing.T, def *FieldDef, req bool, pos int, label, ftype FieldType, delim string) {
 	assert.EqualValues(t, pos, def.Position, "position  mismatch")
 	assert.EqualValues(t, label, def.Label, "label mismatch")
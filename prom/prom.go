@@ -0,0 +1,223 @@
+package prom
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rybit/extractor/sink"
+	"github.com/rybit/extractor/stats"
+)
+
+const defaultPath = "/metrics"
+const defaultMaxSeries = 1000
+
+const (
+	counterKind = "counter"
+	gaugeKind   = "gauge"
+)
+
+type Config struct {
+	Listen string `mapstructure:"listen"`
+	Path   string `mapstructure:"path"`
+
+	// the most label combinations kept for a single metric
+	MaxSeries int `mapstructure:"max_series"`
+}
+
+type family struct {
+	kind   string
+	series map[string]float64
+}
+
+// Exporter keeps the latest value of every metric and serves them
+// in the prometheus text exposition format
+type Exporter struct {
+	config *Config
+	log    *logrus.Entry
+
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+func NewExporter(config *Config, log *logrus.Entry) *Exporter {
+	if config.Path == "" {
+		config.Path = defaultPath
+	}
+	if config.MaxSeries == 0 {
+		config.MaxSeries = defaultMaxSeries
+	}
+
+	return &Exporter{
+		config:   config,
+		log:      log,
+		families: make(map[string]*family),
+	}
+}
+
+// ListenAndServe will block serving the metrics on the configured address
+func (e *Exporter) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.Handle(e.config.Path, e)
+
+	e.log.WithFields(logrus.Fields{
+		"listen": e.config.Listen,
+		"path":   e.config.Path,
+	}).Info("Starting to serve prometheus metrics")
+	return http.ListenAndServe(e.config.Listen, mux)
+}
+
+func (e *Exporter) Name() string {
+	return "prometheus"
+}
+
+// Publish will update the metric. Counters are added to and gauges are set, timers
+// are tracked as a pair of '_sum' (in seconds) and '_count' counters and histograms
+// are a counter with a 'bucket' label.
+func (e *Exporter) Publish(m *sink.Metric) error {
+	name := sanitize(m.Name)
+
+	dims := m.Dims
+	if m.Kind == sink.HistogramKind {
+		dims = map[string]interface{}{"bucket": m.Bucket}
+		for k, v := range m.Dims {
+			dims[k] = v
+		}
+	}
+	labels, err := labelString(dims)
+	if err != nil {
+		return fmt.Errorf("metric %s: %v", name, err)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch m.Kind {
+	case sink.GaugeKind:
+		return e.add(name, gaugeKind, labels, float64(m.Value), true)
+	case sink.TimerKind:
+		// the timer_unit of each metric can differ, prometheus expects seconds
+		if err := e.add(name+"_sum", counterKind, labels, m.Duration.Seconds(), false); err != nil {
+			return err
+		}
+		return e.add(name+"_count", counterKind, labels, 1, false)
+	case sink.HistogramKind:
+		return e.add(name, counterKind, labels, 1, false)
+	}
+	return e.add(name, counterKind, labels, float64(m.Value), false)
+}
+
+func (e *Exporter) Flush() error {
+	return nil
+}
+
+func (e *Exporter) add(name, kind, labels string, value float64, set bool) error {
+	f, ok := e.families[name]
+	if !ok {
+		f = &family{
+			kind:   kind,
+			series: make(map[string]float64),
+		}
+		e.families[name] = f
+	}
+
+	if f.kind != kind {
+		return fmt.Errorf("metric %s is already a %s, dropping the %s", name, f.kind, kind)
+	}
+
+	if _, exists := f.series[labels]; !exists && len(f.series) >= e.config.MaxSeries {
+		stats.Increment("prometheus_series_dropped")
+		return fmt.Errorf("metric %s already has %d series, dropping %s", name, len(f.series), labels)
+	}
+
+	if set {
+		f.series[labels] = value
+	} else {
+		f.series[labels] += value
+	}
+	return nil
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(e.render())
+}
+
+func (e *Exporter) render() []byte {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	names := make([]string, 0, len(e.families))
+	for name := range e.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	for _, name := range names {
+		f := e.families[name]
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			keys = append(keys, labels)
+		}
+		sort.Strings(keys)
+		for _, labels := range keys {
+			fmt.Fprintf(buf, "%s%s %s\n", name, labels, strconv.FormatFloat(f.series[labels], 'f', -1, 64))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// labelString will fail if two dims end up as the same label once they are sanitized,
+// there is no way to know which one was meant
+func labelString(dims map[string]interface{}) (string, error) {
+	if len(dims) == 0 {
+		return "", nil
+	}
+
+	seen := make(map[string]string, len(dims))
+	pairs := make([]string, 0, len(dims))
+	for k, v := range dims {
+		label := sanitize(k)
+		if other, ok := seen[label]; ok {
+			if other > k {
+				other, k = k, other
+			}
+			return "", fmt.Errorf("dims %s and %s are both the label %s", other, k, label)
+		}
+		seen[label] = k
+		pairs = append(pairs, label+"="+escape(fmt.Sprintf("%v", v)))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}", nil
+}
+
+// sanitize will replace anything that isn't valid in a prometheus name with an '_'
+func sanitize(name string) string {
+	out := []byte(name)
+	for i, c := range out {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !valid {
+			out[i] = '_'
+		}
+	}
+	if len(out) == 0 || (out[0] >= '0' && out[0] <= '9') {
+		return "_" + string(out)
+	}
+	return string(out)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,79 @@
+package prom
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rybit/extractor/sink"
+	"github.com/rybit/extractor/stats"
+)
+
+var tl = logrus.WithField("testing", true)
+
+func TestPublishAndRender(t *testing.T) {
+	e := NewExporter(&Config{}, tl)
+	dims := map[string]interface{}{"status": 200, "content-type": `text/"html"`}
+	e.Publish(&sink.Metric{Name: "ats.request_count", Kind: sink.CounterKind, Value: 2, Dims: dims})
+	e.Publish(&sink.Metric{Name: "ats.request_count", Value: 3, Dims: dims})
+	e.Publish(&sink.Metric{Name: "queue.depth", Kind: sink.GaugeKind, Value: 10})
+	e.Publish(&sink.Metric{Name: "queue.depth", Kind: sink.GaugeKind, Value: 7})
+	e.Publish(&sink.Metric{Name: "request.dur", Kind: sink.TimerKind, Value: 25, Duration: 25 * time.Millisecond})
+	e.Publish(&sink.Metric{Name: "request.dur", Kind: sink.TimerKind, Value: 2, Duration: 2 * time.Second})
+	e.Publish(&sink.Metric{Name: "request.size", Kind: sink.HistogramKind, Value: 15, Bucket: "100"})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# TYPE ats_request_count counter
+ats_request_count{content_type="text/\"html\"",status="200"} 5
+# TYPE queue_depth gauge
+queue_depth 7
+# TYPE request_dur_count counter
+request_dur_count 2
+# TYPE request_dur_sum counter
+request_dur_sum 2.025
+# TYPE request_size counter
+request_size{bucket="100"} 1
+`
+	assert.Equal(t, expected, rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+}
+
+func TestMaxSeries(t *testing.T) {
+	stats.Reset()
+	e := NewExporter(&Config{MaxSeries: 2}, tl)
+	for _, user := range []string{"batman", "robin", "joker", "batman"} {
+		e.Publish(&sink.Metric{Name: "logins", Value: 1, Dims: map[string]interface{}{"user": user}})
+	}
+
+	assert.Len(t, e.families["logins"].series, 2)
+	assert.EqualValues(t, 2, e.families["logins"].series[`{user="batman"}`])
+	assert.EqualValues(t, 1, stats.Get("prometheus_series_dropped"))
+}
+
+func TestTypeConflict(t *testing.T) {
+	e := NewExporter(&Config{}, tl)
+	assert.NoError(t, e.Publish(&sink.Metric{Name: "thing", Value: 1}))
+	assert.Error(t, e.Publish(&sink.Metric{Name: "thing", Kind: sink.GaugeKind, Value: 10}))
+	assert.EqualValues(t, 1, e.families["thing"].series[""])
+}
+
+func TestLabelCollision(t *testing.T) {
+	e := NewExporter(&Config{}, tl)
+	err := e.Publish(&sink.Metric{Name: "hits", Value: 1, Dims: map[string]interface{}{"content-type": "a", "content.type": "b"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "content-type and content.type")
+	}
+	assert.Empty(t, e.families)
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t, "ats_request_count", sanitize("ats.request_count"))
+	assert.Equal(t, "content_type", sanitize("content-type"))
+	assert.Equal(t, "_5xx", sanitize("5xx"))
+	assert.Equal(t, "_", sanitize(""))
+}
@@ -0,0 +1,329 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rybit/extractor/stats"
+)
+
+const defaultInfluxBatchSize = 1000
+const defaultInfluxFlushMsec = 1000
+const defaultInfluxRetries = 3
+const defaultInfluxRetryMsec = 500
+const defaultInfluxQueueSize = 10
+const influxUDPPayload = 1432
+
+type InfluxConfig struct {
+	// exactly one of these is required
+	File       string `mapstructure:"file"`
+	UDPAddress string `mapstructure:"udp_address"`
+	URL        string `mapstructure:"url"`
+
+	// required for http, the database to write to
+	Database string `mapstructure:"database"`
+
+	BatchSize int `mapstructure:"batch_size"`
+	FlushMsec int `mapstructure:"flush_msec"`
+	Retries   int `mapstructure:"retries"`
+	RetryMsec int `mapstructure:"retry_msec"`
+
+	// the most full batches waiting to be sent, after that they're dropped
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+type influxSink struct {
+	config  *InfluxConfig
+	write   func([]byte) error
+	log     *logrus.Entry
+	batches chan *influxBatch
+
+	lock  sync.Mutex
+	lines []string
+}
+
+type influxBatch struct {
+	lines []string
+
+	// if set the result of sending the batch is reported on it
+	done chan error
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// NewInfluxSink writes the metrics in the influx line protocol to a file, a UDP
+// socket or an http '/write' endpoint. The lines are batched and sent in the
+// background when the batch is full or every flush interval, failed writes are retried.
+func NewInfluxSink(config *InfluxConfig, log *logrus.Entry) (Sink, error) {
+	if config.BatchSize == 0 {
+		config.BatchSize = defaultInfluxBatchSize
+	}
+	if config.FlushMsec == 0 {
+		config.FlushMsec = defaultInfluxFlushMsec
+	}
+	if config.Retries == 0 {
+		config.Retries = defaultInfluxRetries
+	}
+	if config.RetryMsec == 0 {
+		config.RetryMsec = defaultInfluxRetryMsec
+	}
+	if config.QueueSize == 0 {
+		config.QueueSize = defaultInfluxQueueSize
+	}
+
+	s := &influxSink{
+		config:  config,
+		log:     log,
+		batches: make(chan *influxBatch, config.QueueSize),
+	}
+
+	var err error
+	switch {
+	case config.File != "" && config.UDPAddress == "" && config.URL == "":
+		s.write, err = influxFileWriter(config.File)
+	case config.UDPAddress != "" && config.File == "" && config.URL == "":
+		s.write, err = influxUDPWriter(config.UDPAddress)
+	case config.URL != "" && config.File == "" && config.UDPAddress == "":
+		s.write, err = influxHTTPWriter(config.URL, config.Database)
+	default:
+		err = errors.New("must provide exactly one of a file, udp_address or url for influx")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go s.run()
+	go func() {
+		for range time.Tick(time.Duration(config.FlushMsec) * time.Millisecond) {
+			if err := s.Flush(); err != nil {
+				s.log.WithError(err).Warn("Failed to write metrics to influx")
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *influxSink) Name() string {
+	return "influx"
+}
+
+func (s *influxSink) Publish(m *Metric) error {
+	line := formatInflux(m)
+
+	s.lock.Lock()
+	s.lines = append(s.lines, line)
+	if len(s.lines) < s.config.BatchSize {
+		s.lock.Unlock()
+		return nil
+	}
+	lines := s.take()
+	s.lock.Unlock()
+
+	// the sending and retrying happens in the background so the lines keep being read
+	select {
+	case s.batches <- &influxBatch{lines: lines}:
+		return nil
+	default:
+		return fmt.Errorf("dropped %d lines, there are already %d batches waiting to be sent", len(lines), len(s.batches))
+	}
+}
+
+// Flush will send what is batched up and wait for it, and everything queued before
+// it, to be sent
+func (s *influxSink) Flush() error {
+	s.lock.Lock()
+	lines := s.take()
+	s.lock.Unlock()
+
+	done := make(chan error, 1)
+	s.batches <- &influxBatch{lines: lines, done: done}
+	return <-done
+}
+
+// take must be called with the lock held, it swaps out the batch so it can be sent
+// without blocking the publishers
+func (s *influxSink) take() []string {
+	lines := s.lines
+	s.lines = nil
+	return lines
+}
+
+// run sends the batches in the order they were queued
+func (s *influxSink) run() {
+	for b := range s.batches {
+		err := s.send(b.lines)
+		if b.done != nil {
+			b.done <- err
+			continue
+		}
+		if err != nil {
+			stats.Increment(s.Name() + "_errors")
+			s.log.WithError(err).Warn("Failed to write metrics to influx")
+		}
+	}
+}
+
+// send will write the lines, the batch is dropped if it can't be sent
+func (s *influxSink) send(lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	body := []byte(strings.Join(lines, "\n") + "\n")
+	count := len(lines)
+
+	var err error
+	for attempt := 0; attempt <= s.config.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt*s.config.RetryMsec) * time.Millisecond)
+		}
+
+		if err = s.write(body); err == nil {
+			return nil
+		}
+		if rejected, ok := err.(*influxStatusError); ok && rejected.code/100 == 4 && rejected.code != http.StatusTooManyRequests {
+			// it will be rejected every time, unless it is just being rate limited
+			return fmt.Errorf("dropped %d lines that were rejected: %v", count, err)
+		}
+		s.log.WithError(err).Debugf("Failed to write %d lines to influx on attempt %d", count, attempt+1)
+	}
+
+	return fmt.Errorf("dropped %d lines after %d attempts: %v", count, s.config.Retries+1, err)
+}
+
+func formatInflux(m *Metric) string {
+	tags := []string{}
+	fields := []string{"value=" + strconv.FormatInt(m.Value, 10) + "i"}
+	for k, v := range m.Dims {
+		key := keyEscaper.Replace(k)
+		switch typed := v.(type) {
+		case int:
+			fields = append(fields, key+"="+strconv.Itoa(typed)+"i")
+		case int32:
+			fields = append(fields, key+"="+strconv.FormatInt(int64(typed), 10)+"i")
+		case int64:
+			fields = append(fields, key+"="+strconv.FormatInt(typed, 10)+"i")
+		case float32:
+			fields = append(fields, key+"="+strconv.FormatFloat(float64(typed), 'f', -1, 32))
+		case float64:
+			fields = append(fields, key+"="+strconv.FormatFloat(typed, 'f', -1, 64))
+		default:
+			val := fmt.Sprintf("%v", v)
+			if val != "" {
+				tags = append(tags, key+"="+keyEscaper.Replace(val))
+			}
+		}
+	}
+	if m.Bucket != "" {
+		tags = append(tags, "bucket="+keyEscaper.Replace(m.Bucket))
+	}
+	sort.Strings(tags)
+	sort.Strings(fields[1:])
+
+	when := m.Timestamp
+	if when.IsZero() {
+		when = time.Now()
+	}
+
+	series := measurementEscaper.Replace(m.Name)
+	if len(tags) > 0 {
+		series += "," + strings.Join(tags, ",")
+	}
+	return fmt.Sprintf("%s %s %d", series, strings.Join(fields, ","), when.UnixNano())
+}
+
+func influxFileWriter(path string) (func([]byte) error, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(body []byte) error {
+		_, err := f.Write(body)
+		return err
+	}, nil
+}
+
+func influxUDPWriter(address string) (func([]byte) error, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(body []byte) error {
+		// split it into datagrams on line boundaries
+		for len(body) > 0 {
+			end := len(body)
+			if end > influxUDPPayload {
+				end = bytes.LastIndexByte(body[:influxUDPPayload], '\n') + 1
+				if end <= 0 {
+					// a single line that is too long, send it anyways
+					end = bytes.IndexByte(body, '\n') + 1
+				}
+			}
+
+			if _, err := conn.Write(body[:end]); err != nil {
+				return err
+			}
+			body = body[end:]
+		}
+		return nil
+	}, nil
+}
+
+func influxHTTPWriter(base, database string) (func([]byte) error, error) {
+	if database == "" {
+		return nil, errors.New("must provide a database to write to influx over http")
+	}
+
+	u, err := url.Parse(strings.TrimRight(base, "/") + "/write")
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("db", database)
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+	writeURL := u.String()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(body []byte) error {
+		rsp, err := client.Post(writeURL, "text/plain; charset=utf-8", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer rsp.Body.Close()
+
+		if rsp.StatusCode/100 != 2 {
+			msg, _ := ioutil.ReadAll(rsp.Body)
+			return &influxStatusError{code: rsp.StatusCode, msg: strings.TrimSpace(string(msg))}
+		}
+		return nil
+	}, nil
+}
+
+type influxStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *influxStatusError) Error() string {
+	return fmt.Sprintf("influx responded with %d: %s", e.code, e.msg)
+}
@@ -0,0 +1,193 @@
+package sink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatInflux(t *testing.T) {
+	when := time.Unix(1486000000, 123)
+	m := &Metric{
+		Name:      "ats.request dur",
+		Kind:      TimerKind,
+		Value:     250,
+		Timestamp: when,
+		Dims: map[string]interface{}{
+			"status":  503,
+			"ratio":   0.5,
+			"domain":  "gotham.com",
+			"method":  "GET",
+			"cached":  false,
+			"user,id": "bat man",
+		},
+	}
+
+	expected := `ats.request\ dur,cached=false,domain=gotham.com,method=GET,user\,id=bat\ man value=250i,ratio=0.5,status=503i 1486000000000000123`
+	assert.Equal(t, expected, formatInflux(m))
+
+	m = &Metric{Name: "size", Kind: HistogramKind, Value: 12, Bucket: "100", Timestamp: when}
+	assert.Equal(t, "size,bucket=100 value=12i 1486000000000000123", formatInflux(m))
+}
+
+func TestInfluxFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	s, err := NewInfluxSink(&InfluxConfig{File: f.Name(), BatchSize: 2, FlushMsec: 60000}, tl)
+	require.NoError(t, err)
+
+	when := time.Unix(10, 0)
+	assert.NoError(t, s.Publish(&Metric{Name: "one", Value: 1, Timestamp: when}))
+	bs, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Empty(t, bs)
+
+	// fills the batch
+	assert.NoError(t, s.Publish(&Metric{Name: "two", Value: 2, Timestamp: when}))
+	assert.NoError(t, s.Publish(&Metric{Name: "three", Value: 3, Timestamp: when}))
+	assert.NoError(t, s.Flush())
+
+	bs, err = ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "one value=1i 10000000000\ntwo value=2i 10000000000\nthree value=3i 10000000000\n", string(bs))
+}
+
+func TestInfluxHTTPRetries(t *testing.T) {
+	calls := 0
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "/write", r.URL.Path)
+		assert.Equal(t, "metrics", r.URL.Query().Get("db"))
+		bs, _ := ioutil.ReadAll(r.Body)
+		body = string(bs)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxSink(&InfluxConfig{URL: server.URL, Database: "metrics", FlushMsec: 60000, RetryMsec: 1}, tl)
+	require.NoError(t, err)
+
+	assert.NoError(t, s.Publish(&Metric{Name: "one", Value: 1, Timestamp: time.Unix(10, 0)}))
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "one value=1i 10000000000\n", body)
+}
+
+func TestInfluxHTTPGivesUp(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxSink(&InfluxConfig{URL: server.URL, Database: "metrics", FlushMsec: 60000, Retries: 2, RetryMsec: 1}, tl)
+	require.NoError(t, err)
+
+	assert.NoError(t, s.Publish(&Metric{Name: "one", Value: 1}))
+	assert.Error(t, s.Flush())
+	assert.Equal(t, 3, calls)
+
+	// the batch is dropped
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, 3, calls)
+}
+
+func TestInfluxConfig(t *testing.T) {
+	_, err := NewInfluxSink(&InfluxConfig{}, tl)
+	assert.Error(t, err)
+
+	_, err = NewInfluxSink(&InfluxConfig{File: "/tmp/nothing", URL: "http://localhost"}, tl)
+	assert.Error(t, err)
+
+	_, err = NewInfluxSink(&InfluxConfig{URL: "http://localhost"}, tl)
+	assert.Error(t, err)
+}
+
+func TestInfluxHTTPDoesntRetryRejected(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxSink(&InfluxConfig{URL: server.URL, Database: "metrics", FlushMsec: 60000, Retries: 2, RetryMsec: 1}, tl)
+	require.NoError(t, err)
+
+	assert.NoError(t, s.Publish(&Metric{Name: "one", Value: 1}))
+	assert.Error(t, s.Flush())
+	assert.Equal(t, 1, calls)
+}
+
+func TestInfluxHTTPRetriesRateLimited(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := NewInfluxSink(&InfluxConfig{URL: server.URL, Database: "metrics", FlushMsec: 60000, Retries: 2, RetryMsec: 1}, tl)
+	require.NoError(t, err)
+
+	assert.NoError(t, s.Publish(&Metric{Name: "one", Value: 1}))
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, 2, calls)
+}
+
+func TestInfluxPublishWhileSending(t *testing.T) {
+	sending := make(chan bool)
+	release := make(chan bool)
+	s := &influxSink{
+		config:  &InfluxConfig{BatchSize: 1, Retries: 1, RetryMsec: 1},
+		log:     tl,
+		batches: make(chan *influxBatch, 1),
+		write: func([]byte) error {
+			sending <- true
+			<-release
+			return nil
+		},
+	}
+	go s.run()
+
+	// the full batch is sent in the background
+	assert.NoError(t, s.Publish(&Metric{Name: "one", Value: 1}))
+	<-sending
+
+	published := make(chan error)
+	go func() { published <- s.Publish(&Metric{Name: "two", Value: 2}) }()
+	select {
+	case err := <-published:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Failed to publish while a batch was being sent")
+	}
+
+	// the queue is full
+	assert.Error(t, s.Publish(&Metric{Name: "three", Value: 3}))
+
+	flushed := make(chan error)
+	go func() { flushed <- s.Flush() }()
+	close(release)
+	<-sending
+	assert.NoError(t, <-flushed)
+	assert.Empty(t, s.lines)
+}
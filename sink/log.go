@@ -0,0 +1,33 @@
+package sink
+
+import (
+	"encoding/json"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type logSink struct {
+	log *logrus.Entry
+}
+
+// NewLogSink writes each metric to the logger as JSON
+func NewLogSink(log *logrus.Entry) Sink {
+	return &logSink{log: log}
+}
+
+func (s *logSink) Name() string {
+	return "log"
+}
+
+func (s *logSink) Publish(m *Metric) error {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.log.Info(string(bs))
+	return nil
+}
+
+func (s *logSink) Flush() error {
+	return nil
+}
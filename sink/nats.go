@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats"
+	"github.com/rybit/nats_metrics"
+)
+
+type natsSink struct {
+	nc      *nats.Conn
+	subject string
+
+	// publish is the connection's, it is swapped out in the tests
+	publish func(subject string, data []byte) error
+}
+
+// NewNatsSink publishes using the metrics lib, which must already be initialized.
+// A metric with its own subject, or a timer which the lib can't send with a given
+// duration, is published directly on the connection. The subject is the same one
+// the lib publishes on.
+func NewNatsSink(nc *nats.Conn, subject string) Sink {
+	s := &natsSink{
+		nc:      nc,
+		subject: subject,
+	}
+	if nc != nil {
+		s.publish = nc.Publish
+	}
+	return s
+}
+
+func (s *natsSink) Name() string {
+	return "nats"
+}
+
+func (s *natsSink) Publish(m *Metric) error {
+	if m.Subject != "" {
+		return s.publishTo(m.Subject, m)
+	}
+
+	dims := metrics.DimMap(m.Dims)
+	switch m.Kind {
+	case GaugeKind:
+		g := metrics.NewGauge(m.Name, nil)
+		if !m.Timestamp.IsZero() {
+			g.SetTimestamp(m.Timestamp)
+		}
+		return g.Set(m.Value, &dims)
+	case TimerKind:
+		// timers in the lib can only time something themselves
+		return s.publishTo(s.subject, m)
+	case HistogramKind:
+		// there is no histogram type, so it is a counter per bucket
+		withBucket := metrics.DimMap{"bucket": m.Bucket}
+		for k, v := range dims {
+			withBucket[k] = v
+		}
+		c := metrics.NewCounter(m.Name, nil)
+		if !m.Timestamp.IsZero() {
+			c.SetTimestamp(m.Timestamp)
+		}
+		return c.Count(&withBucket)
+	default:
+		c := metrics.NewCounter(m.Name, nil)
+		if !m.Timestamp.IsZero() {
+			c.SetTimestamp(m.Timestamp)
+		}
+		return c.CountN(m.Value, &dims)
+	}
+}
+
+// publishTo does the same as the metrics lib, but on another subject
+func (s *natsSink) publishTo(subject string, m *Metric) error {
+	if s.publish == nil {
+		return errors.New("there is no connection to publish on " + subject)
+	}
+
+	raw := &metrics.RawMetric{
+		Name:      m.Name,
+		Value:     m.Value,
+		Timestamp: m.Timestamp,
+		Dims:      metrics.DimMap{},
+	}
+	for k, v := range m.Dims {
+		raw.Dims[k] = v
+	}
+	if raw.Timestamp.IsZero() {
+		raw.Timestamp = time.Now()
+	}
+
+	switch m.Kind {
+	case GaugeKind:
+		raw.Type = metrics.GaugeType
+	case TimerKind:
+		raw.Type = metrics.TimerType
+		raw.Value = int64(m.Duration)
+	case HistogramKind:
+		raw.Type = metrics.CounterType
+		raw.Value = 1
+		raw.Dims["bucket"] = m.Bucket
+	default:
+		raw.Type = metrics.CounterType
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return s.publish(subject, data)
+}
+
+func (s *natsSink) Flush() error {
+	if s.nc == nil {
+		return nil
+	}
+	return s.nc.Flush()
+}
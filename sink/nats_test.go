@@ -0,0 +1,48 @@
+package sink
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rybit/nats_metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNatsTimer(t *testing.T) {
+	sent := map[string][]byte{}
+	s := &natsSink{
+		subject: "metrics",
+		publish: func(subject string, data []byte) error {
+			sent[subject] = data
+			return nil
+		},
+	}
+
+	when := time.Unix(1480375544, 0)
+	for _, m := range []*Metric{
+		{Name: "testing-timer", Kind: TimerKind, Value: 250, Duration: 250 * time.Millisecond, Timestamp: when, Dims: map[string]interface{}{"hostname": "gotham", "hero": "batman"}},
+		{Name: "testing-subject", Kind: GaugeKind, Value: 12, Subject: "heroes"},
+	} {
+		require.NoError(t, s.Publish(m))
+	}
+
+	raw := new(metrics.RawMetric)
+	require.NoError(t, json.Unmarshal(sent["metrics"], raw))
+	assert.Equal(t, "testing-timer", raw.Name)
+	assert.Equal(t, metrics.TimerType, raw.Type)
+	assert.Equal(t, int64(250*time.Millisecond), raw.Value)
+	assert.True(t, when.Equal(raw.Timestamp))
+	assert.Equal(t, metrics.DimMap{"hostname": "gotham", "hero": "batman"}, raw.Dims)
+
+	raw = new(metrics.RawMetric)
+	require.NoError(t, json.Unmarshal(sent["heroes"], raw))
+	assert.Equal(t, metrics.GaugeType, raw.Type)
+	assert.EqualValues(t, 12, raw.Value)
+}
+
+func TestNatsTimerWithoutConnection(t *testing.T) {
+	s := NewNatsSink(nil, "metrics")
+	assert.Error(t, s.Publish(&Metric{Name: "testing-timer", Kind: TimerKind, Duration: time.Second}))
+}
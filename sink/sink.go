@@ -0,0 +1,113 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rybit/extractor/stats"
+)
+
+const (
+	CounterKind   = "counter"
+	GaugeKind     = "gauge"
+	TimerKind     = "timer"
+	HistogramKind = "histogram"
+)
+
+// Metric is a single value extracted from a line
+type Metric struct {
+	Name      string                 `json:"name"`
+	Kind      string                 `json:"kind"`
+	Value     int64                  `json:"value"`
+	Timestamp time.Time              `json:"timestamp"`
+	Dims      map[string]interface{} `json:"dims"`
+
+	// set for timers, the value converted using the metric's unit
+	Duration time.Duration `json:"duration,omitempty"`
+	// set for histograms, the upper bound of the bucket that the value is in
+	Bucket string `json:"bucket,omitempty"`
+	// optional, the subject to publish on instead of the default one
+	Subject string `json:"subject,omitempty"`
+}
+
+// Sink is somewhere to send the metrics
+type Sink interface {
+	Name() string
+	Publish(m *Metric) error
+	Flush() error
+}
+
+type withSubject struct {
+	next    Sink
+	subject string
+}
+
+// WithSubject will set the subject on each metric before passing it to the next sink
+func WithSubject(next Sink, subject string) Sink {
+	return &withSubject{
+		next:    next,
+		subject: subject,
+	}
+}
+
+func (s *withSubject) Name() string {
+	return s.next.Name()
+}
+
+func (s *withSubject) Publish(m *Metric) error {
+	m.Subject = s.subject
+	return s.next.Publish(m)
+}
+
+func (s *withSubject) Flush() error {
+	return s.next.Flush()
+}
+
+type multi struct {
+	sinks []Sink
+	log   *logrus.Entry
+}
+
+// NewMulti will send each metric to all the sinks, keeping track of
+// how many metrics each one has published or failed on in the stats
+func NewMulti(sinks []Sink, log *logrus.Entry) Sink {
+	return &multi{
+		sinks: sinks,
+		log:   log,
+	}
+}
+
+func (m *multi) Name() string {
+	return "multi"
+}
+
+func (m *multi) Publish(metric *Metric) error {
+	var firstErr error
+	for _, s := range m.sinks {
+		if err := s.Publish(metric); err != nil {
+			stats.Increment(s.Name() + "_errors")
+			m.log.WithError(err).WithField("sink", s.Name()).Debugf("Failed to publish %s", metric.Name)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		stats.Increment(s.Name() + "_published")
+	}
+	return firstErr
+}
+
+func (m *multi) Flush() error {
+	var firstErr error
+	for _, s := range m.sinks {
+		if err := s.Flush(); err != nil {
+			stats.Increment(s.Name() + "_errors")
+			m.log.WithError(err).WithField("sink", s.Name()).Warn("Failed to flush")
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
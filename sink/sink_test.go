@@ -0,0 +1,53 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rybit/extractor/stats"
+)
+
+var tl = logrus.WithField("testing", true)
+
+type testSink struct {
+	name      string
+	err       error
+	published []*Metric
+	flushed   int
+}
+
+func (s *testSink) Name() string { return s.name }
+func (s *testSink) Flush() error {
+	s.flushed++
+	return s.err
+}
+func (s *testSink) Publish(m *Metric) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.published = append(s.published, m)
+	return nil
+}
+
+func TestMulti(t *testing.T) {
+	stats.Reset()
+	good := &testSink{name: "good"}
+	bad := &testSink{name: "bad", err: errors.New("nope")}
+	out := NewMulti([]Sink{good, bad}, tl)
+
+	m := &Metric{Name: "thing", Kind: CounterKind, Value: 1}
+	assert.Error(t, out.Publish(m))
+	assert.Error(t, out.Publish(m))
+	assert.Error(t, out.Flush())
+
+	assert.Len(t, good.published, 2)
+	assert.Equal(t, 1, good.flushed)
+	assert.Equal(t, 1, bad.flushed)
+	assert.EqualValues(t, 2, stats.Get("good_published"))
+	assert.EqualValues(t, 0, stats.Get("good_errors"))
+	assert.EqualValues(t, 0, stats.Get("bad_published"))
+	assert.EqualValues(t, 3, stats.Get("bad_errors"))
+}
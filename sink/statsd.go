@@ -0,0 +1,150 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultStatsdAddress = "127.0.0.1:8125"
+const defaultStatsdMTU = 1432
+const defaultStatsdFlushMsec = 1000
+
+type StatsdConfig struct {
+	Address string `mapstructure:"address"`
+	Prefix  string `mapstructure:"prefix"`
+
+	// the most bytes sent in a single datagram
+	MTU       int `mapstructure:"mtu"`
+	FlushMsec int `mapstructure:"flush_msec"`
+
+	// if set the dimensions are sent as DogStatsD tags, otherwise they're dropped
+	DogStatsd bool `mapstructure:"dogstatsd"`
+}
+
+type statsdSink struct {
+	config *StatsdConfig
+	conn   net.Conn
+	log    *logrus.Entry
+
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_")
+
+// NewStatsdSink sends the metrics over UDP to a statsd agent. Several metrics are
+// batched into a datagram, which is sent when it is full or every flush interval.
+func NewStatsdSink(config *StatsdConfig, log *logrus.Entry) (Sink, error) {
+	if config.Address == "" {
+		config.Address = defaultStatsdAddress
+	}
+	if config.MTU == 0 {
+		config.MTU = defaultStatsdMTU
+	}
+	if config.FlushMsec == 0 {
+		config.FlushMsec = defaultStatsdFlushMsec
+	}
+
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	if !config.DogStatsd {
+		log.Warn("Plain statsd has no tags, the dimensions of every metric will be dropped. Set 'dogstatsd' to send them.")
+	}
+
+	s := &statsdSink{
+		config: config,
+		conn:   conn,
+		log:    log,
+	}
+
+	go func() {
+		for range time.Tick(time.Duration(config.FlushMsec) * time.Millisecond) {
+			if err := s.Flush(); err != nil {
+				s.log.WithError(err).Warn("Failed to send metrics to statsd")
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *statsdSink) Name() string {
+	return "statsd"
+}
+
+func (s *statsdSink) Publish(m *Metric) error {
+	line := s.format(m)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// make room for it if needed, a line that is too big is sent on its own
+	if s.buf.Len() > 0 && s.buf.Len()+1+len(line) > s.config.MTU {
+		if err := s.send(); err != nil {
+			return err
+		}
+	}
+
+	if s.buf.Len() > 0 {
+		s.buf.WriteByte('\n')
+	}
+	s.buf.WriteString(line)
+	return nil
+}
+
+func (s *statsdSink) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.send()
+}
+
+// send must be called with the lock held
+func (s *statsdSink) send() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := s.conn.Write(s.buf.Bytes())
+	s.buf.Reset()
+	return err
+}
+
+func (s *statsdSink) format(m *Metric) string {
+	name := statsdEscaper.Replace(m.Name)
+	if s.config.Prefix != "" {
+		name = s.config.Prefix + "." + name
+	}
+
+	var value, kind string
+	switch m.Kind {
+	case GaugeKind:
+		value, kind = strconv.FormatInt(m.Value, 10), "g"
+	case TimerKind:
+		value, kind = strconv.FormatFloat(float64(m.Duration)/float64(time.Millisecond), 'f', -1, 64), "ms"
+	case HistogramKind:
+		value, kind = strconv.FormatInt(m.Value, 10), "h"
+	default:
+		value, kind = strconv.FormatInt(m.Value, 10), "c"
+	}
+
+	line := fmt.Sprintf("%s:%s|%s", name, value, kind)
+	if s.config.DogStatsd && len(m.Dims) > 0 {
+		tags := make([]string, 0, len(m.Dims))
+		for k, v := range m.Dims {
+			tags = append(tags, statsdEscaper.Replace(k)+":"+statsdEscaper.Replace(fmt.Sprintf("%v", v)))
+		}
+		sort.Strings(tags)
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
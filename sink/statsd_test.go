@@ -0,0 +1,66 @@
+package sink
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T) (*net.UDPConn, func() string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+
+	return conn, func() string {
+		buf := make([]byte, 2048)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestStatsdFormat(t *testing.T) {
+	conn, read := listen(t)
+	defer conn.Close()
+
+	s, err := NewStatsdSink(&StatsdConfig{Address: conn.LocalAddr().String(), FlushMsec: 60000, DogStatsd: true}, tl)
+	require.NoError(t, err)
+
+	dims := map[string]interface{}{"status": 200, "domain": "gotham.com"}
+	assert.NoError(t, s.Publish(&Metric{Name: "requests", Kind: CounterKind, Value: 2, Dims: dims}))
+	assert.NoError(t, s.Publish(&Metric{Name: "queue", Kind: GaugeKind, Value: 10}))
+	assert.NoError(t, s.Publish(&Metric{Name: "dur", Kind: TimerKind, Value: 1500, Duration: 1500 * time.Microsecond}))
+	assert.NoError(t, s.Publish(&Metric{Name: "size", Kind: HistogramKind, Value: 123, Bucket: "1000"}))
+	assert.NoError(t, s.Flush())
+
+	expected := strings.Join([]string{
+		"requests:2|c|#domain:gotham.com,status:200",
+		"queue:10|g",
+		"dur:1.5|ms",
+		"size:123|h",
+	}, "\n")
+	assert.Equal(t, expected, read())
+}
+
+func TestStatsdBatchesToMTU(t *testing.T) {
+	conn, read := listen(t)
+	defer conn.Close()
+
+	s, err := NewStatsdSink(&StatsdConfig{Address: conn.LocalAddr().String(), FlushMsec: 60000, MTU: 30, Prefix: "ats"}, tl)
+	require.NoError(t, err)
+
+	// each is 16 bytes, so only one fits in a datagram
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Publish(&Metric{Name: "requests", Value: 1, Dims: map[string]interface{}{"dropped": true}}))
+	}
+	assert.Equal(t, "ats.requests:1|c", read())
+	assert.Equal(t, "ats.requests:1|c", read())
+	assert.NoError(t, s.Flush())
+	assert.Equal(t, "ats.requests:1|c", read())
+}
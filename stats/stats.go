@@ -13,7 +13,7 @@ import (
 type Config struct {
 	Interval int    `mapstructure:"report_sec"`
 	Subject  string `mapstructure:"subject"`
-	Prefix   string `mpastructure:"prefix"`
+	Prefix   string `mapstructure:"prefix"`
 }
 
 var statLock sync.Mutex
@@ -24,12 +24,6 @@ func ReportStats(config *Config, log *logrus.Entry, dims *map[string]interface{}
 		log.Debug("Skipping stats reporting because it is configured off")
 		return
 	}
-	fields := logrus.Fields{}
-	dimMap := metrics.DimMap{}
-	for k, v := range *dims {
-		dimMap[k] = v
-		fields[k] = v
-	}
 
 	go func() {
 		log.WithFields(logrus.Fields{
@@ -39,27 +33,40 @@ func ReportStats(config *Config, log *logrus.Entry, dims *map[string]interface{}
 		}).Infof("Starting to report stats every %d seconds", config.Interval)
 		ticks := time.Tick(time.Duration(config.Interval) * time.Second)
 		for range ticks {
-			go func() {
-				statLock.Lock()
-				if bs, err := json.Marshal(&stats); err == nil {
-					log.WithFields(fields).Infof(string(bs))
-				}
+			go Report(config, log, dims)
+		}
+	}()
+}
 
-				if config.Subject != "" {
-					for k, v := range stats {
-						name := config.Prefix
-						if name != "" {
-							name += "."
-						}
-						name += k
+// Report will log the current stats and publish them as gauges if there is a subject.
+// Without a config they are only logged.
+func Report(config *Config, log *logrus.Entry, dims *map[string]interface{}) {
+	fields := logrus.Fields{}
+	dimMap := metrics.DimMap{}
+	if dims != nil {
+		for k, v := range *dims {
+			dimMap[k] = v
+			fields[k] = v
+		}
+	}
+
+	statLock.Lock()
+	defer statLock.Unlock()
+	if bs, err := json.Marshal(&stats); err == nil {
+		log.WithFields(fields).Infof(string(bs))
+	}
+
+	if config != nil && config.Subject != "" {
+		for k, v := range stats {
+			name := config.Prefix
+			if name != "" {
+				name += "."
+			}
+			name += k
 
-						metrics.NewGauge(name, nil).Set(v, &dimMap)
-					}
-				}
-				statLock.Unlock()
-			}()
+			metrics.NewGauge(name, nil).Set(v, &dimMap)
 		}
-	}()
+	}
 }
 
 func Increment(key string) {
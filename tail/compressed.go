@@ -0,0 +1,102 @@
+package tail
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/rybit/extractor/stats"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+
+	// after the magic and the block size a bzip2 stream starts with a block or its end
+	bzip2Block = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2End   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// decompress will wrap the reader in a decompressor if it starts with the magic bytes of
+// gzip, zstd or bzip2. If it isn't compressed the reader is nil, and if the header after
+// the magic bytes isn't valid it is an error.
+func decompress(f io.ReaderAt) (io.ReadCloser, string, error) {
+	header := make([]byte, zstd.HeaderMaxSize)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	header = header[:n]
+
+	stream := io.NewSectionReader(f, 0, math.MaxInt64)
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		r, err := gzip.NewReader(stream)
+		if err != nil {
+			return nil, "gzip", err
+		}
+		return r, "gzip", nil
+	case bytes.HasPrefix(header, zstdMagic):
+		// the reader doesn't look at the header until the first read
+		var h zstd.Header
+		if err := h.Decode(header); err != nil {
+			return nil, "zstd", err
+		}
+		r, err := zstd.NewReader(stream)
+		if err != nil {
+			return nil, "zstd", err
+		}
+		return r.IOReadCloser(), "zstd", nil
+	case bytes.HasPrefix(header, bzip2Magic):
+		// neither does this one, the magic is followed by the block size from 1 to 9
+		if len(header) < 10 || header[3] < '1' || header[3] > '9' {
+			return nil, "bzip2", errors.New("invalid bzip2 header")
+		}
+		if !bytes.Equal(header[4:10], bzip2Block) && !bytes.Equal(header[4:10], bzip2End) {
+			return nil, "bzip2", errors.New("invalid bzip2 block magic")
+		}
+		return ioutil.NopCloser(bzip2.NewReader(stream)), "bzip2", nil
+	}
+	return nil, "", nil
+}
+
+// readCompressed will read the whole file through a decompressor if it is compressed.
+// It is false if the file isn't compressed and should be read as it is.
+func (c *consumer) readCompressed() bool {
+	if c.file == nil {
+		if _, err := c.open(); err != nil {
+			// it is retried when reading it normally
+			return false
+		}
+	}
+
+	r, kind, err := decompress(c.file)
+	if err != nil {
+		// it only looks compressed, or it couldn't be checked and reading it will retry
+		c.log.WithError(err).Warnf("Failed to start reading the %s file, reading it as plain text", kind)
+		return false
+	}
+	if r == nil {
+		return false
+	}
+	defer r.Close()
+
+	l := c.log.WithField("compression", kind)
+	l.Info("Starting to read the compressed file")
+	lines, err := c.readLines(r)
+	if err != nil {
+		// there is no going back in the stream, so this is as far as we can get
+		l.WithError(err).Warnf("Failed to read the compressed file after %d lines", lines)
+		stats.Increment("read_errors")
+		return true
+	}
+	l.Debugf("Finished reading the compressed file %d lines", lines)
+	return true
+}
@@ -0,0 +1,109 @@
+package tail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// "first line\nsecond line" from the bzip2 command, there isn't a writer in the stdlib
+var bzip2Lines = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x42, 0x28, 0x6a, 0x3c, 0x00, 0x00,
+	0x04, 0x51, 0x80, 0x00, 0x10, 0x40, 0x00, 0x0f, 0x25, 0x9c, 0x00, 0x20, 0x00, 0x21, 0xa9, 0x90,
+	0xc1, 0x90, 0x80, 0x68, 0x03, 0xcb, 0xef, 0x61, 0x33, 0xe0, 0xba, 0x04, 0x82, 0xc5, 0x66, 0x8b,
+	0xb9, 0x22, 0x9c, 0x28, 0x48, 0x21, 0x14, 0x35, 0x1e, 0x00,
+}
+
+func TestReadCompressed(t *testing.T) {
+	raw := []byte("first line\nsecond line")
+
+	gz := new(bytes.Buffer)
+	w := gzip.NewWriter(gz)
+	w.Write(raw)
+	require.NoError(t, w.Close())
+
+	zst := new(bytes.Buffer)
+	enc, err := zstd.NewWriter(zst)
+	require.NoError(t, err)
+	enc.Write(raw)
+	require.NoError(t, enc.Close())
+
+	for kind, data := range map[string][]byte{
+		"plain": raw,
+		"gzip":  gz.Bytes(),
+		"zstd":  zst.Bytes(),
+		"bzip2": bzip2Lines,
+	} {
+		f, err := ioutil.TempFile("", "extractor-testing")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+		f.Write(data)
+		f.Close()
+
+		c := newConsumer(f.Name(), tl)
+		c.Follow = false
+		lines := []string{}
+		stopped := make(chan bool)
+		go func() {
+			for l := range c.Out {
+				lines = append(lines, l)
+			}
+			stopped <- true
+		}()
+		go c.consume()
+		require.NoError(t, waitFor(stopped, 5), kind)
+
+		assert.Equal(t, []string{"first line", "second line"}, lines, kind)
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	r, kind, err := decompress(bytes.NewReader([]byte{0x1f, 0x8b, 0x00}))
+	assert.Error(t, err)
+	assert.Nil(t, r)
+	assert.Equal(t, "gzip", kind)
+
+	r, kind, err = decompress(bytes.NewReader([]byte("just a line\n")))
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+	assert.Empty(t, kind)
+}
+
+func TestReadLooksCompressed(t *testing.T) {
+	for kind, data := range map[string]string{
+		"bzip2 magic":      "BZh is what I said\nsecond line",
+		"bzip2 block size": "BZh9 is what I said\nsecond line",
+		"gzip":             "\x1f\x8b is what I said\nsecond line",
+		"zstd":             "\x28\xb5\x2f\xfd\x08 is what I said\nsecond line",
+	} {
+		_, _, err := decompress(bytes.NewReader([]byte(data)))
+		assert.Error(t, err, kind)
+
+		f, err := ioutil.TempFile("", "extractor-testing")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+		f.WriteString(data)
+		f.Close()
+
+		c := newConsumer(f.Name(), tl)
+		c.Follow = false
+		lines := []string{}
+		stopped := make(chan bool)
+		go func() {
+			for l := range c.Out {
+				lines = append(lines, l)
+			}
+			stopped <- true
+		}()
+		go c.consume()
+		require.NoError(t, waitFor(stopped, 5), kind)
+
+		assert.Equal(t, []string{data[:len(data)-len("\nsecond line")], "second line"}, lines, kind)
+	}
+}
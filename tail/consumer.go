@@ -2,28 +2,64 @@ package tail
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"os"
-	"sync"
 	"time"
 
 	"syscall"
 
 	"github.com/Sirupsen/logrus"
+
+	"github.com/rybit/extractor/checkpoint"
+	"github.com/rybit/extractor/stats"
 )
 
+const defaultMaxLineLength = 1024 * 1024
+
+// while watching the file it is still checked every so often in case an event is missed
+const watchedInterval = 30 * time.Second
+
+// how long a removed file is kept open in case it is still being written to or comes back
+const defaultRemovedGrace = 10 * time.Second
+
 type consumer struct {
-	Path         string
-	log          *logrus.Entry
-	shutdown     chan bool
-	shutdownLock sync.Mutex
-	isShutdown   bool
+	Path     string
+	log      *logrus.Entry
+	shutdown chan bool
 
 	FromEnd  bool
 	Follow   bool
 	offset   int64
+	file     *os.File
+	inode    uint64
 	Duration time.Duration
 	Out      chan string
+
+	// the longest line to send, longer ones are truncated or skipped
+	MaxLineLength int
+	SkipLongLines bool
+
+	// what has been read after the last newline
+	partial   []byte
+	pending   int64
+	truncated bool
+
+	// once it is shutting down the lines are sent without watching for a shutdown
+	shuttingDown bool
+
+	// Poll will check the file every Duration instead of watching it for changes
+	Poll bool
+
+	// once the path has been gone for this long the file is finished and we stop
+	RemovedGrace time.Duration
+	removedAt    time.Time
+
+	// optional, used to resume from where we left off
+	Checkpoints        *checkpoint.Store
+	CheckpointInterval time.Duration
+	Fallback           string
+	lastFlush          time.Time
 }
 
 func newConsumer(path string, log *logrus.Entry) *consumer {
@@ -34,102 +70,381 @@ func newConsumer(path string, log *logrus.Entry) *consumer {
 		Out:      make(chan string),
 		Duration: time.Second,
 		Follow:   true,
+
+		MaxLineLength: defaultMaxLineLength,
+		RemovedGrace:  defaultRemovedGrace,
 	}
 }
 
 func (c *consumer) consume() {
-	if c.FromEnd {
-		info, err := os.Stat(c.Path)
+	if isStream(c.Path) {
+		c.consumeStream()
+		return
+	}
+
+	if c.FromEnd || c.Checkpoints != nil {
+		info, err := c.open()
 		for err != nil {
-			c.log.WithError(err).Warn("Failed to stat the file while seeking to the end, will retry in a few")
-			time.Sleep(time.Second)
-			info, err = os.Stat(c.Path)
+			c.log.WithError(err).Warn("Failed to open the file while finding the start position, will retry in a few")
+			select {
+			case <-c.shutdown:
+				c.log.Debug("Shutting down before the file was opened")
+				close(c.Out)
+				return
+			case <-time.After(time.Second):
+			}
+			info, err = c.open()
 		}
 
-		c.offset = info.Size()
-		c.log.Debugf("Moved to end of the file: %d", c.offset)
+		if !c.resume(info) {
+			switch c.Fallback {
+			case checkpoint.FallbackStart:
+				c.offset = 0
+			case checkpoint.FallbackEnd:
+				c.offset = info.Size()
+			default:
+				if c.FromEnd {
+					c.offset = info.Size()
+				}
+			}
+		}
+		c.log.Debugf("Starting at offset: %d", c.offset)
+	}
+
+	if !c.Follow && c.readCompressed() {
+		c.stop()
+		return
 	}
 
-	var lastInode uint64
+	var events <-chan struct{}
+	interval := c.Duration
+	if c.Follow && !c.Poll {
+		w, err := newWatcher(c.Path, c.log)
+		if err != nil {
+			c.log.WithError(err).Warn("Failed to watch the file, falling back to polling")
+		} else {
+			defer w.Close()
+			events = w.Events
+			interval = watchedInterval
+		}
+	}
 
+	// the first read is right away, after that it is on an event or the interval
+	wait := time.Duration(0)
 	for {
 		select {
 		case <-c.shutdown:
 			c.log.Debug("Shutting down")
-			c.shutdownLock.Lock()
-			c.isShutdown = true
-			close(c.Out)
-			c.shutdownLock.Unlock()
-		case <-time.After(c.Duration):
-			c.shutdownLock.Lock()
-			if c.isShutdown {
-				c.shutdownLock.Unlock()
-				return
+			c.stop()
+			return
+		case _, ok := <-events:
+			if !ok {
+				c.log.Warn("Stopped watching the file, falling back to polling")
+				events = nil
+				interval = c.Duration
 			}
-			c.shutdownLock.Unlock()
+		case <-time.After(wait):
+		}
+		wait = interval
 
-			info, err := os.Stat(c.Path)
-			if err != nil {
-				c.log.WithError(err).Warn("Failed to stat the file, will retry in a few")
-				continue
-			}
+		if finished := c.read(); finished || !c.Follow {
+			c.stop()
+			return
+		}
+		if !c.removedAt.IsZero() && wait > c.RemovedGrace {
+			// there won't be any events for a removed file, so check back once the grace is up
+			wait = c.RemovedGrace
+		}
+	}
+}
 
-			if info.Size() < c.offset {
-				c.log.Info("File rotation detected by decreasing size - adjusting seek to the beginning")
-				c.offset = 0
-			}
+// stop will send the last partial line, store where we got to and close Out. When
+// following with checkpoints the partial line isn't sent, it is read again when we
+// resume once the rest of it is written.
+func (c *consumer) stop() {
+	c.shuttingDown = true
+	if !c.Follow || c.Checkpoints == nil {
+		c.flushPartial()
+	}
+	if c.file != nil {
+		c.saveCheckpoint()
+		c.file.Close()
+	}
+	c.flushCheckpoints(true)
+	close(c.Out)
+}
 
-			if stats, ok := info.Sys().(*syscall.Stat_t); ok {
-				if lastInode != stats.Ino {
-					if lastInode != 0 {
-						c.offset = 0
-						c.log.Info("File rotation detected by inode change, adjusting to the beginning")
-					}
+// read will scan the open file from the offset to the end. If the path is now a
+// different file the old one is finished first, then closed and the new one is read
+// from the beginning. It returns true once the path has been removed for longer than
+// the grace period, the file is read to the end by then, or if it was shut down.
+func (c *consumer) read() bool {
+	if c.file == nil {
+		if _, err := c.open(); err != nil {
+			c.log.WithError(err).Warn("Failed to open the file, will retry in a few")
+			return false
+		}
+	}
 
-					lastInode = stats.Ino
-				}
-			}
+	info, err := c.file.Stat()
+	if err != nil {
+		c.log.WithError(err).Warn("Failed to stat the file, will retry in a few")
+		return false
+	}
 
-			// open the file
-			f, err := os.Open(c.Path)
-			if err != nil {
-				c.log.WithError(err).Warn("Failed to open the file, will retry in a few")
-				continue
-			}
+	if info.Size() < c.offset {
+		c.log.Info("File rotation detected by decreasing size - adjusting seek to the beginning")
+		c.offset = 0
+		c.resetLine()
+	}
 
-			if _, err := f.Seek(c.offset, io.SeekStart); err != nil {
-				c.log.Warnf("Failed to seek to %d, going to go to the beginning", c.offset)
-				c.offset = 0
-				continue
-			}
+	rotated := false
+	removed := false
+	if current, err := os.Stat(c.Path); err == nil {
+		rotated = !os.SameFile(info, current)
+	} else if os.IsNotExist(err) {
+		removed = true
+	} else {
+		c.log.WithError(err).Warn("Failed to stat the path to check for rotation")
+	}
 
-			c.log.Debugf("Starting to scan file from offset: %d", c.offset)
-			scanner := bufio.NewScanner(f)
-			linesScanned := 0
-			for scanner.Scan() {
-				c.shutdownLock.Lock()
-				if c.isShutdown {
-					return
-				} else {
-					c.Out <- scanner.Text()
-					linesScanned++
-				}
-				c.shutdownLock.Unlock()
-			}
-			c.log.Debugf("Finished scanning file %d lines", linesScanned)
+	c.scan()
+	if c.shuttingDown {
+		return true
+	}
+	if removed {
+		if c.removedAt.IsZero() {
+			c.log.Infof("The file was removed, will stop reading it if it isn't back in %s", c.RemovedGrace)
+			c.removedAt = time.Now()
+		}
+		if time.Since(c.removedAt) < c.RemovedGrace {
+			return false
+		}
+		c.log.Info("The file was removed and didn't come back, finished reading it")
+		return true
+	}
+	c.removedAt = time.Time{}
+	if !rotated {
+		return false
+	}
 
-			// now store the offset again
-			off, err := f.Seek(0, io.SeekCurrent)
-			if err != nil {
-				c.log.Warn("Failed to update the offset of the file")
-				off = 0
-			}
+	c.log.WithField("old_inode", c.inode).Info("File rotation detected by inode change, finished the old file and moving to the beginning of the new one")
+	c.flushPartial()
+	c.file.Close()
+	c.file = nil
+	c.offset = 0
+	if _, err := c.open(); err != nil {
+		c.log.WithError(err).Warn("Failed to open the new file, will retry in a few")
+		return false
+	}
+	c.scan()
+	return c.shuttingDown
+}
 
-			c.offset = off
+// open will open the path, the handle is kept until the file is rotated or we shutdown
+func (c *consumer) open() (os.FileInfo, error) {
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return nil, err
+	}
 
-			if !c.Follow {
-				c.shutdown <- true
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	c.file = f
+	c.inode = inode(info)
+	return info, nil
+}
+
+// scan will send every complete line from the offset to the end of the file. Anything
+// after the last newline is kept until the rest of the line is written.
+func (c *consumer) scan() {
+	if _, err := c.file.Seek(c.offset, io.SeekStart); err != nil {
+		c.log.Warnf("Failed to seek to %d, going to go to the beginning", c.offset)
+		c.offset = 0
+		c.resetLine()
+		return
+	}
+
+	c.log.Debugf("Starting to scan file from offset: %d", c.offset)
+	linesScanned, err := c.readLines(c.file)
+	if err != nil {
+		// go back to the start of the line and try it again on the next read
+		c.log.WithError(err).Warnf("Failed to read the file at offset %d, will retry in a few", c.offset)
+		stats.Increment("read_errors")
+		c.offset -= c.pending
+		c.resetLine()
+	}
+	c.log.Debugf("Finished scanning file %d lines, %d bytes left without a newline", linesScanned, c.pending)
+
+	c.saveCheckpoint()
+}
+
+// readLines will send every complete line until the end of the reader, or until it is
+// shut down while sending one
+func (c *consumer) readLines(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	lines := 0
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		c.offset += int64(len(chunk))
+		c.pending += int64(len(chunk))
+		switch err {
+		case nil:
+			c.keep(chunk[:len(chunk)-1])
+			if !c.sendLine() {
+				return lines, nil
 			}
+			lines++
+		case bufio.ErrBufferFull:
+			c.keep(chunk)
+		case io.EOF:
+			c.keep(chunk)
+			return lines, nil
+		default:
+			return lines, err
+		}
+	}
+}
+
+// keep will add to the current line, anything past the max line length is dropped
+func (c *consumer) keep(b []byte) {
+	room := c.MaxLineLength - len(c.partial)
+	if len(b) > room {
+		c.truncated = true
+		if room < 0 {
+			room = 0
 		}
+		b = b[:room]
+	}
+	c.partial = append(c.partial, b...)
+}
+
+// sendLine will send the current line, unless it is shut down first. Then the line is
+// left unread so it is read again when we resume from the checkpoint.
+func (c *consumer) sendLine() bool {
+	line := bytes.TrimSuffix(c.partial, []byte{'\r'})
+	size := c.pending
+	truncated := c.truncated
+	c.resetLine()
+
+	if truncated {
+		if c.SkipLongLines {
+			c.log.Debugf("Skipping a line longer than %d bytes", c.MaxLineLength)
+			stats.Increment("long_lines_skipped")
+			return true
+		}
+		c.log.Debugf("Truncating a line longer than %d bytes", c.MaxLineLength)
+		stats.Increment("long_lines_truncated")
+	}
+
+	if c.shuttingDown {
+		c.Out <- string(line)
+		return true
+	}
+	select {
+	case c.Out <- string(line):
+		return true
+	case <-c.shutdown:
+		c.log.Debug("Shutting down while sending a line")
+		c.shuttingDown = true
+		c.offset -= size
+		return false
+	}
+}
+
+func (c *consumer) resetLine() {
+	c.partial = nil
+	c.pending = 0
+	c.truncated = false
+}
+
+// flushPartial will send whatever is left without a newline as the last line
+func (c *consumer) flushPartial() {
+	if c.pending == 0 {
+		return
+	}
+	c.sendLine()
+}
+
+// resume will move to the checkpointed offset if there is one for this file
+func (c *consumer) resume(info os.FileInfo) bool {
+	if c.Checkpoints == nil {
+		return false
+	}
+
+	entry, ok := c.Checkpoints.Get(c.Path)
+	if !ok {
+		c.log.Info("No checkpoint found for the file")
+		return false
+	}
+
+	l := c.log.WithFields(logrus.Fields{
+		"checkpoint_offset": entry.Offset,
+		"checkpoint_inode":  entry.Inode,
+	})
+	if entry.Offset > info.Size() {
+		l.Infof("The file is smaller than the checkpoint, ignoring it")
+		return false
+	}
+
+	if !entry.Matches(c.file, c.inode) {
+		if c.Fallback != checkpoint.FallbackResume {
+			l.Info("The checkpoint doesn't match the file, ignoring it")
+			return false
+		}
+		l.Info("The checkpoint doesn't match the file, but resuming from it anyways")
+	}
+
+	l.Info("Resuming from checkpoint")
+	c.offset = entry.Offset
+	return true
+}
+
+func (c *consumer) saveCheckpoint() {
+	if c.Checkpoints == nil {
+		return
+	}
+
+	// the partial line is read again when we resume
+	offset := c.offset - c.pending
+	fp, n, err := checkpoint.Fingerprint(c.file, offset)
+	if err != nil {
+		c.log.WithError(err).Warn("Failed to fingerprint the file for a checkpoint")
+		return
+	}
+
+	c.Checkpoints.Set(c.Path, checkpoint.Entry{
+		Inode:          c.inode,
+		Offset:         offset,
+		Fingerprint:    fp,
+		FingerprintLen: n,
+	})
+	c.flushCheckpoints(false)
+}
+
+func (c *consumer) flushCheckpoints(force bool) {
+	if c.Checkpoints == nil {
+		return
+	}
+
+	if !force && time.Since(c.lastFlush) < c.CheckpointInterval {
+		return
+	}
+
+	if err := c.Checkpoints.Flush(); err != nil {
+		c.log.WithError(err).Warn("Failed to write checkpoints")
+		return
+	}
+	c.lastFlush = time.Now()
+}
+
+func inode(info os.FileInfo) uint64 {
+	if stats, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stats.Ino
 	}
+	return 0
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +13,9 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/rybit/extractor/checkpoint"
+	"github.com/rybit/extractor/stats"
 )
 
 var tl = logrus.WithField("testing", true)
@@ -68,6 +72,37 @@ func TestConsumer(t *testing.T) {
 	}
 }
 
+func TestConsumerWithPolling(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := newConsumer(f.Name(), tl)
+	c.Poll = true
+	c.Duration = 100 * time.Millisecond
+	stopped := make(chan bool)
+	stages := make(chan bool)
+	go func() {
+		linesSeen := 0
+		for range c.Out {
+			linesSeen++
+			if linesSeen == 10 {
+				stages <- true
+			}
+		}
+		stopped <- true
+	}()
+	go c.consume()
+
+	for i := 0; i < 10; i++ {
+		f.WriteString(fmt.Sprintf("this is a line %d\n", i))
+	}
+
+	require.NoError(t, waitFor(stages, 2))
+	c.shutdown <- true
+	require.NoError(t, waitFor(stopped, 2))
+}
+
 func TestReadWithTruncate(t *testing.T) {
 	f, err := ioutil.TempFile("", "extractor-testing")
 	require.NoError(t, err)
@@ -172,6 +207,216 @@ func TestReadRemoval(t *testing.T) {
 	}
 }
 
+func TestReadRemovedFileStops(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer f.Close()
+
+	c := newConsumer(f.Name(), tl)
+	c.RemovedGrace = 100 * time.Millisecond
+
+	lines := make(chan string, 20)
+	go func() {
+		for l := range c.Out {
+			lines <- l
+		}
+		close(lines)
+	}()
+	go c.consume()
+
+	f.WriteString("before removal\n")
+	select {
+	case l := <-lines:
+		assert.Equal(t, "before removal", l)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Failed to read the first line")
+	}
+
+	// the writer still has it open, what it writes is read until the grace is up
+	require.NoError(t, os.Remove(f.Name()))
+	f.WriteString("after removal\n")
+
+	var seen []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				assert.Equal(t, []string{"after removal"}, seen)
+				_, err := c.file.Stat()
+				assert.Error(t, err, "the file should be closed")
+				return
+			}
+			seen = append(seen, l)
+		case <-timeout:
+			require.FailNow(t, "Failed to stop after the file was removed")
+		}
+	}
+}
+
+func TestShutdownWhileSending(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("first\nsecond\nthird\n")
+	f.Close()
+
+	c := newConsumer(f.Name(), tl)
+	go c.consume()
+
+	select {
+	case l := <-c.Out:
+		assert.Equal(t, "first", l)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Failed to read the first line")
+	}
+
+	// nothing is reading the second line
+	c.shutdown <- true
+	select {
+	case l, ok := <-c.Out:
+		assert.False(t, ok, "got the line '%s' after shutting down", l)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Failed to shut down while sending a line")
+	}
+
+	// the second line is read again when it resumes
+	assert.EqualValues(t, len("first\n"), c.offset)
+}
+
+func TestReadDrainsRotatedFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + ".1")
+
+	c := newConsumer(f.Name(), tl)
+	c.Poll = true
+	c.Duration = 500 * time.Millisecond
+
+	lines := make(chan string, 20)
+	go func() {
+		for l := range c.Out {
+			lines <- l
+		}
+		close(lines)
+	}()
+	go c.consume()
+
+	f.WriteString("before rotation\n")
+	select {
+	case l := <-lines:
+		assert.Equal(t, "before rotation", l)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Failed to read the first line")
+	}
+
+	// the writer still has the old file open after it is moved
+	require.NoError(t, os.Rename(f.Name(), f.Name()+".1"))
+	f.WriteString("after rotation\n")
+	f.Close()
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("in the new file\n"), 0644))
+
+	expected := []string{"after rotation", "in the new file"}
+	for _, e := range expected {
+		select {
+		case l := <-lines:
+			assert.Equal(t, e, l)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "Failed to read the line: "+e)
+		}
+	}
+
+	c.shutdown <- true
+	for range lines {
+		assert.Fail(t, "Read more lines than expected")
+	}
+}
+
+func TestReadPartialLines(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	c := newConsumer(f.Name(), tl)
+	c.Poll = true
+	c.Duration = 100 * time.Millisecond
+
+	lines := make(chan string, 10)
+	go func() {
+		for l := range c.Out {
+			lines <- l
+		}
+		close(lines)
+	}()
+	go c.consume()
+
+	// give it a few reads with only half of the line there
+	f.WriteString("this is half")
+	<-time.After(300 * time.Millisecond)
+	f.WriteString(" of a line\r\nand the last")
+
+	select {
+	case l := <-lines:
+		assert.Equal(t, "this is half of a line", l)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Failed to read the line")
+	}
+
+	select {
+	case l := <-lines:
+		assert.Fail(t, "Read a line without a newline: "+l)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// it is sent when we stop
+	c.shutdown <- true
+	select {
+	case l := <-lines:
+		assert.Equal(t, "and the last", l)
+	case <-time.After(2 * time.Second):
+		require.FailNow(t, "Failed to get the last line on shutdown")
+	}
+}
+
+func TestReadLongLines(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		stats.Reset()
+		f, err := ioutil.TempFile("", "extractor-testing")
+		require.NoError(t, err)
+		defer os.Remove(f.Name())
+
+		// it is longer than the reader's buffer too
+		f.WriteString(strings.Repeat("x", 10000) + "\n")
+		f.WriteString("short\n")
+
+		c := newConsumer(f.Name(), tl)
+		c.Follow = false
+		c.MaxLineLength = 100
+		c.SkipLongLines = skip
+
+		lines := []string{}
+		stopped := make(chan bool)
+		go func() {
+			for l := range c.Out {
+				lines = append(lines, l)
+			}
+			stopped <- true
+		}()
+		go c.consume()
+		require.NoError(t, waitFor(stopped, 5))
+
+		if skip {
+			assert.Equal(t, []string{"short"}, lines)
+			assert.EqualValues(t, 1, stats.Get("long_lines_skipped"))
+		} else if assert.Len(t, lines, 2) {
+			assert.Equal(t, strings.Repeat("x", 100), lines[0])
+			assert.Equal(t, "short", lines[1])
+			assert.EqualValues(t, 1, stats.Get("long_lines_truncated"))
+		}
+	}
+}
+
 func waitFor(c <-chan bool, sec int) error {
 	select {
 	case <-c:
@@ -227,3 +472,105 @@ func TestReadFromEndOfFile(t *testing.T) {
 		assert.Fail(t, "Failed to get messages in time")
 	}
 }
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	state, err := ioutil.TempFile("", "extractor-testing-state")
+	require.NoError(t, err)
+	defer os.Remove(state.Name())
+
+	store, err := checkpoint.Load(state.Name())
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		f.WriteString(fmt.Sprintf("this is a line %d\n", i))
+	}
+
+	c := newConsumer(f.Name(), tl)
+	c.Checkpoints = store
+	stopped := make(chan bool)
+	stages := make(chan bool)
+	go func() {
+		linesSeen := 0
+		for range c.Out {
+			linesSeen++
+			if linesSeen == 10 {
+				stages <- true
+			}
+		}
+		stopped <- true
+	}()
+	go c.consume()
+	require.NoError(t, waitFor(stages, 2))
+	c.shutdown <- true
+	require.NoError(t, waitFor(stopped, 5))
+
+	// these are written while nothing is running
+	for i := 0; i < 5; i++ {
+		f.WriteString(fmt.Sprintf("this is also a line %d\n", i))
+	}
+
+	store, err = checkpoint.Load(state.Name())
+	require.NoError(t, err)
+
+	c = newConsumer(f.Name(), tl)
+	c.FromEnd = true
+	c.Checkpoints = store
+	lines := []string{}
+	go func() {
+		for l := range c.Out {
+			lines = append(lines, l)
+		}
+		stopped <- true
+	}()
+	go c.consume()
+	<-time.After(time.Second * 2)
+	c.shutdown <- true
+
+	require.NoError(t, waitFor(stopped, 5))
+	if assert.Len(t, lines, 5) {
+		assert.Equal(t, "this is also a line 0", lines[0])
+	}
+}
+
+func TestResumePartialLineFromCheckpoint(t *testing.T) {
+	f, err := ioutil.TempFile("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	state, err := ioutil.TempFile("", "extractor-testing-state")
+	require.NoError(t, err)
+	defer os.Remove(state.Name())
+
+	f.WriteString("the first line\nthis is half")
+
+	consume := func() []string {
+		store, err := checkpoint.Load(state.Name())
+		require.NoError(t, err)
+
+		c := newConsumer(f.Name(), tl)
+		c.Checkpoints = store
+		lines := []string{}
+		stopped := make(chan bool)
+		go func() {
+			for l := range c.Out {
+				lines = append(lines, l)
+			}
+			stopped <- true
+		}()
+		go c.consume()
+		<-time.After(500 * time.Millisecond)
+		c.shutdown <- true
+		require.NoError(t, waitFor(stopped, 5))
+		return lines
+	}
+
+	// the half of a line isn't sent, the checkpoint is at the start of it
+	assert.Equal(t, []string{"the first line"}, consume())
+
+	f.WriteString(" of a line\n")
+	assert.Equal(t, []string{"this is half of a line"}, consume())
+}
@@ -0,0 +1,100 @@
+package tail
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/rybit/extractor/conf"
+	"github.com/rybit/extractor/parsing"
+	"github.com/rybit/extractor/sink"
+)
+
+// DryRun is what a single metric made of a line
+type DryRun struct {
+	Metric string
+	Tokens []string
+	Fields map[int]parsing.ParsedField
+	// the metric that would be published, nil if the line was rejected
+	Output *sink.Metric
+	// why the line was rejected
+	Rejected string
+	// anything that was logged while parsing the line
+	Notes []string
+}
+
+// TryLine will run the line through each of the metrics for the path without publishing
+// anything, keeping track of what was parsed and why the line was rejected. The metrics
+// get the same dims they would when following the path, an empty path uses the top level
+// metrics and leaves out the source_dim.
+func TryLine(line string, config *conf.Config, path string) []DryRun {
+	text := strings.TrimSpace(line)
+
+	set := newMetricSet(config, path, nil)
+
+	results := []DryRun{}
+	for _, m := range set.defs {
+		r := DryRun{
+			Metric: m.Name,
+			Tokens: m.Tokens(text),
+		}
+		if text == "" {
+			r.Rejected = "the line is blank"
+			results = append(results, r)
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		logger := logrus.New()
+		logger.Out = buf
+		logger.Level = logrus.DebugLevel
+		logger.Formatter = new(noteFormatter)
+		log := logrus.NewEntry(logger)
+
+		// parse it on its own first, extracting the metric takes fields out
+		r.Fields, _, _ = m.ParseLine(text, log)
+		r.Notes = notes(buf)
+		buf.Reset()
+
+		metric, err := extract(m, text, set.dims, log)
+		switch {
+		case err == errNotParsed:
+			r.Rejected = err.Error()
+			if len(r.Notes) > 0 {
+				// the last thing logged is what stopped it
+				r.Rejected = r.Notes[len(r.Notes)-1]
+				r.Notes = r.Notes[:len(r.Notes)-1]
+			}
+		case err != nil:
+			r.Rejected = err.Error()
+		default:
+			r.Output = metric
+		}
+		results = append(results, r)
+	}
+
+	return results
+}
+
+func notes(buf *bytes.Buffer) []string {
+	out := []string{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// noteFormatter keeps only the message and the error
+type noteFormatter struct{}
+
+func (f *noteFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	msg := e.Message
+	if err, ok := e.Data[logrus.ErrorKey]; ok {
+		msg = fmt.Sprintf("%s: %v", msg, err)
+	}
+	return []byte(strings.Replace(msg, "\n", " ", -1) + "\n"), nil
+}
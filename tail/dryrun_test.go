@@ -0,0 +1,126 @@
+package tail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rybit/extractor/conf"
+	"github.com/rybit/extractor/parsing"
+)
+
+func TestTryLine(t *testing.T) {
+	one := 1
+	defs := []conf.MetricDef{
+		{Name: "testing-ok", Kind: conf.GaugeKind, Fields: []parsing.FieldDef{{Position: 0}, {Position: 1, Type: parsing.NumberType}}, ValueField: &one},
+		{Name: "testing-required", Fields: []parsing.FieldDef{{Position: 3, Required: true}}},
+		{Name: "testing-munge", Fields: []parsing.FieldDef{{Position: 0}}, MungeDef: &conf.MungeDef{FieldNumber: 2}},
+	}
+	for i := range defs {
+		require.NoError(t, defs[i].Compile())
+	}
+
+	results := TryLine("hero=batman size=12", &conf.Config{Metrics: defs}, "")
+	require.Len(t, results, 3)
+
+	ok := results[0]
+	assert.Equal(t, []string{"hero=batman", "size=12"}, ok.Tokens)
+	assert.Equal(t, "batman", ok.Fields[0].Value)
+	assert.Empty(t, ok.Rejected)
+	if assert.NotNil(t, ok.Output) {
+		assert.EqualValues(t, 12, ok.Output.Value)
+		assert.Equal(t, "batman", ok.Output.Dims["hero"])
+	}
+
+	required := results[1]
+	assert.Nil(t, required.Output)
+	assert.Contains(t, required.Rejected, "Missing required field at position 3")
+
+	munge := results[2]
+	assert.Nil(t, munge.Output)
+	assert.Contains(t, munge.Rejected, "failed to extract a name")
+
+	zero := 0
+	dropped := conf.MetricDef{Name: "testing-drop", Fields: []parsing.FieldDef{{Position: 0}}, Drop: &conf.Condition{FieldIndex: &zero, Regex: "batman"}}
+	require.NoError(t, dropped.Compile())
+	for _, r := range TryLine("hero=batman", &conf.Config{Metrics: []conf.MetricDef{dropped}}, "") {
+		assert.Nil(t, r.Output)
+		assert.Equal(t, "the line passes the drop condition", r.Rejected)
+	}
+
+	for _, r := range TryLine("  ", &conf.Config{Metrics: defs}, "") {
+		assert.Equal(t, "the line is blank", r.Rejected)
+	}
+}
+
+func TestTryLineWithDims(t *testing.T) {
+	config := &conf.Config{
+		Dims:      &map[string]interface{}{"app": "default", "host": "gotham"},
+		SourceDim: "source",
+		Metrics:   []conf.MetricDef{{Name: "testing-top", Fields: []parsing.FieldDef{{Position: 0}}}},
+		Sources: []conf.SourceDef{{
+			Path:    "/var/log/*.log",
+			Dims:    map[string]interface{}{"app": "web"},
+			Metrics: []conf.MetricDef{{Name: "testing-source", Fields: []parsing.FieldDef{{Position: 0}}}},
+		}},
+	}
+	require.NoError(t, config.Sources[0].Compile())
+	require.NoError(t, config.Metrics[0].Compile())
+
+	results := TryLine("hero=batman", config, "/var/log/access.log")
+	require.Len(t, results, 1)
+	if m := results[0].Output; assert.NotNil(t, m, results[0].Rejected) {
+		assert.Equal(t, "testing-source", m.Name)
+		assert.Equal(t, map[string]interface{}{
+			"app":    "web",
+			"host":   "gotham",
+			"source": "/var/log/access.log",
+			"hero":   "batman",
+		}, m.Dims)
+	}
+
+	results = TryLine("hero=batman", config, "")
+	require.Len(t, results, 1)
+	if m := results[0].Output; assert.NotNil(t, m, results[0].Rejected) {
+		assert.Equal(t, "testing-top", m.Name)
+		assert.Equal(t, map[string]interface{}{"app": "default", "host": "gotham", "hero": "batman"}, m.Dims)
+	}
+}
+
+func TestTryLineWithTypedFields(t *testing.T) {
+	zero := 0
+	defs := []conf.MetricDef{
+		{
+			Name:            "testing-typed",
+			Format:          conf.JSONFormat,
+			Fields:          []parsing.FieldDef{{Path: "ts", Type: parsing.NumberType}, {Path: "status", Type: parsing.NumberType}},
+			TimestampField:  &zero,
+			TimestampFormat: "sec",
+			MungeDef:        &conf.MungeDef{FieldNumber: 1},
+		},
+		{
+			Name:            "testing-bool-time",
+			Format:          conf.JSONFormat,
+			Fields:          []parsing.FieldDef{{Path: "ok", Type: parsing.BoolType}},
+			TimestampField:  &zero,
+			TimestampFormat: "sec",
+		},
+	}
+	for i := range defs {
+		require.NoError(t, defs[i].Compile())
+		require.NoError(t, defs[i].Validate())
+	}
+
+	results := TryLine(`{"ts": 1480375544, "status": 503, "ok": true}`, &conf.Config{Metrics: defs}, "")
+	require.Len(t, results, 2)
+
+	if typed := results[0]; assert.NotNil(t, typed.Output, typed.Rejected) {
+		assert.Equal(t, "testing-typed_503", typed.Output.Name)
+		assert.Equal(t, int64(1480375544), typed.Output.Timestamp.Unix())
+	}
+
+	boolTime := results[1]
+	assert.Nil(t, boolTime.Output)
+	assert.Contains(t, boolTime.Rejected, "failed to extract a timestamp")
+}
@@ -0,0 +1,93 @@
+package tail
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rybit/extractor/stats"
+)
+
+// StdinPath is the path to use to read from stdin
+const StdinPath = "-"
+
+// isStream is true for stdin and named pipes, which can't be seeked or checkpointed
+func isStream(path string) bool {
+	if path == StdinPath {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// consumeStream will read lines from stdin or a named pipe until it ends. When following
+// a named pipe it is opened for writing as well, so it doesn't end when a writer closes
+// it and the next one can pick up where it left off.
+func (c *consumer) consumeStream() {
+	defer close(c.Out)
+
+	var r io.ReadCloser = os.Stdin
+	if c.Path != StdinPath {
+		flag := os.O_RDONLY
+		if c.Follow {
+			flag = os.O_RDWR
+		}
+
+		// without a writer this blocks until there is one
+		f, err := os.OpenFile(c.Path, flag, 0)
+		if err != nil {
+			c.log.WithError(err).Warn("Failed to open the named pipe")
+			return
+		}
+		r = f
+	}
+
+	// a read on a blocking fd like stdin isn't stopped by closing it, so the reads are
+	// copied through a pipe that we can close on shutdown without waiting for them
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, r)
+		pw.CloseWithError(err)
+	}()
+
+	var lock sync.Mutex
+	stopped := false
+	halt := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		if stopped {
+			return
+		}
+		stopped = true
+		pw.Close()
+		// this stops the read that is in progress if it can be stopped
+		r.Close()
+	}
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-c.shutdown:
+			c.log.Debug("Shutting down")
+			halt()
+		case <-done:
+		}
+	}()
+
+	c.log.Info("Starting to read the stream")
+	lines, err := c.readLines(pr)
+	if c.shuttingDown {
+		// the shutdown came while sending a line
+		halt()
+	}
+
+	lock.Lock()
+	wasStopped := stopped
+	lock.Unlock()
+	if err != nil && !wasStopped {
+		c.log.WithError(err).Warnf("Failed to read the stream after %d lines", lines)
+		stats.Increment("read_errors")
+	}
+	c.flushPartial()
+	c.log.Debugf("Finished reading the stream %d lines", lines)
+}
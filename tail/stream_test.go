@@ -0,0 +1,115 @@
+package tail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowNamedPipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pipe")
+	require.NoError(t, syscall.Mkfifo(path, 0600))
+	assert.True(t, isStream(path))
+	assert.True(t, isStream(StdinPath))
+	assert.False(t, isStream(dir))
+
+	c := newConsumer(path, tl)
+	lines := make(chan string, 10)
+	stopped := make(chan bool)
+	go func() {
+		for l := range c.Out {
+			lines <- l
+		}
+		stopped <- true
+	}()
+	go c.consume()
+
+	// one writer after another, the second one is still read
+	for _, line := range []string{"first writer", "second writer"} {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		w.WriteString(line + "\n")
+		w.Close()
+
+		select {
+		case l := <-lines:
+			assert.Equal(t, line, l)
+		case <-time.After(2 * time.Second):
+			require.FailNow(t, "Failed to read from the pipe")
+		}
+	}
+
+	c.shutdown <- true
+	require.NoError(t, waitFor(stopped, 2))
+}
+
+func TestProcessNamedPipe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pipe")
+	require.NoError(t, syscall.Mkfifo(path, 0600))
+
+	c := newConsumer(path, tl)
+	c.Follow = false
+	lines := []string{}
+	stopped := make(chan bool)
+	go func() {
+		for l := range c.Out {
+			lines = append(lines, l)
+		}
+		stopped <- true
+	}()
+	go c.consume()
+
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	w.WriteString("a line\nand one without a newline")
+	w.Close()
+
+	// it is done once the writer closes it
+	require.NoError(t, waitFor(stopped, 2))
+	assert.Equal(t, []string{"a line", "and one without a newline"}, lines)
+}
+
+func TestStopReadingStdin(t *testing.T) {
+	// a blocking fd like `sleep 30 | extractor follow -` can't be stopped by closing it
+	fds := make([]int, 2)
+	require.NoError(t, syscall.Pipe(fds))
+	r := os.NewFile(uintptr(fds[0]), "stdin")
+	w := os.NewFile(uintptr(fds[1]), "writer")
+	defer w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	c := newConsumer(StdinPath, tl)
+	lines := []string{}
+	stopped := make(chan bool)
+	go func() {
+		for l := range c.Out {
+			lines = append(lines, l)
+		}
+		stopped <- true
+	}()
+	go c.consume()
+
+	w.WriteString("a line\nand one without a newline")
+	time.Sleep(100 * time.Millisecond)
+
+	c.shutdown <- true
+	require.NoError(t, waitFor(stopped, 2))
+	assert.Equal(t, []string{"a line", "and one without a newline"}, lines)
+}
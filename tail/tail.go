@@ -1,20 +1,23 @@
 package tail
 
 import (
+	"errors"
 	"io"
-	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/rybit/nats_metrics"
 
 	"strconv"
 
 	"fmt"
 
+	"github.com/rybit/extractor/checkpoint"
 	"github.com/rybit/extractor/conf"
 	"github.com/rybit/extractor/parsing"
+	"github.com/rybit/extractor/sink"
 	"github.com/rybit/extractor/stats"
 )
 
@@ -22,107 +25,429 @@ const nanoInMsec int64 = 1000000
 
 var zero time.Time
 
-func ProcessFile(config *conf.Config, path string, log *logrus.Entry, seek int, follow bool) chan bool {
-	if path == "" {
+// Processor keeps track of every file that is being consumed so they can be stopped
+// or given new metrics together
+type Processor struct {
+	lock    sync.Mutex
+	files   []*tailedFile
+	skipped []string
+	stopped bool
+	stop    chan bool
+	wg      sync.WaitGroup
+
+	// the settings for the consumers are from the first config, only the metrics
+	// and sources of the latest one are used
+	config  *conf.Config
+	current *conf.Config
+	store   *checkpoint.Store
+	out     sink.Sink
+	log     *logrus.Entry
+	follow  bool
+}
+
+// tailedFile is a file that is being consumed and where to send its new metrics
+type tailedFile struct {
+	path     string
+	consumer *consumer
+	reloads  chan *metricSet
+}
+
+// metricSet is everything needed to turn the lines of a file into metrics, it is
+// replaced as a whole when the config is reloaded
+type metricSet struct {
+	defs    []conf.MetricDef
+	dims    map[string]interface{}
+	out     sink.Sink
+	source  string
+	subject string
+}
+
+// ProcessFiles will consume every file that matches the patterns, each with its own
+// consumer. When following the patterns are checked again every retry interval,
+// any new files are consumed from the beginning and the files that don't match
+// anymore are stopped. The path "-" is stdin.
+func ProcessFiles(config *conf.Config, patterns []string, out sink.Sink, log *logrus.Entry, seek int, follow bool) *Processor {
+	if len(patterns) == 0 {
 		log.Fatal("Must provide a path to consume")
 	}
 
-	notFound := true
-	for notFound {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			log.Warnf("File %s does not exist, will check again in %d seconds", path, config.RetrySec)
-			<-time.After(time.Duration(config.RetrySec) * time.Second)
-		} else {
-			notFound = false
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.WithError(err).Fatalf("Invalid path pattern '%s'", pattern)
+		}
+	}
+
+	var store *checkpoint.Store
+	if config.CheckpointConf != nil && follow {
+		var err error
+		store, err = checkpoint.Load(config.CheckpointConf.File)
+		if err != nil {
+			log.WithError(err).Fatalf("Failed to load checkpoints from %s", config.CheckpointConf.File)
+		}
+	}
+
+	p := &Processor{
+		stop:    make(chan bool),
+		config:  config,
+		current: config,
+		store:   store,
+		out:     out,
+		log:     log,
+		follow:  follow,
+	}
+	started := make(map[string]bool)
+	scan := func(fromEnd bool) map[string]bool {
+		matched := make(map[string]bool)
+		for _, pattern := range patterns {
+			// the pattern is already checked
+			paths, _ := filepath.Glob(pattern)
+			if pattern == StdinPath {
+				paths = []string{StdinPath}
+			}
+			for _, path := range paths {
+				matched[path] = true
+				if started[path] {
+					continue
+				}
+				started[path] = true
+				p.processFile(path, fromEnd)
+			}
+		}
+		return matched
+	}
+
+	retry := time.Duration(config.RetrySec) * time.Second
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for len(scan(seek == io.SeekEnd)) == 0 {
+			log.Warnf("No files match %v, will check again in %d seconds", patterns, config.RetrySec)
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(retry):
+			}
+		}
+
+		if !follow {
+			return
+		}
+
+		ticks := time.NewTicker(retry)
+		defer ticks.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticks.C:
+				for _, path := range p.forget(scan(false)) {
+					// it is started again if it matches later on
+					delete(started, path)
+				}
+			}
+		}
+	}()
+
+	return p
+}
+
+// forget will stop the consumers of the files that don't match the patterns anymore,
+// and stop waiting on the skipped ones. It returns the paths that were forgotten.
+func (p *Processor) forget(matched map[string]bool) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	forgotten := []string{}
+	files := p.files[:0]
+	for _, f := range p.files {
+		if matched[f.path] {
+			files = append(files, f)
+			continue
+		}
+
+		p.log.WithField("path", f.path).Info("The file doesn't match anymore, stopping it")
+		select {
+		case f.consumer.shutdown <- true:
+		default:
+		}
+		forgotten = append(forgotten, f.path)
+	}
+	p.files = files
+
+	skipped := p.skipped[:0]
+	for _, path := range p.skipped {
+		if matched[path] {
+			skipped = append(skipped, path)
+			continue
+		}
+		forgotten = append(forgotten, path)
+	}
+	p.skipped = skipped
+
+	return forgotten
+}
+
+// Stop will stop looking for new files and shut down all the consumers. It returns
+// once all the lines that they read are processed.
+func (p *Processor) Stop() {
+	p.lock.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+	for _, f := range p.files {
+		// if it is full the consumer is already shutting down
+		select {
+		case f.consumer.shutdown <- true:
+		default:
 		}
 	}
+	p.lock.Unlock()
+
+	p.wg.Wait()
+}
 
-	log.WithField("path", path).Info("Found file to process")
+// Wait returns once all the consumers have finished, which only happens on its own
+// when they aren't following the files
+func (p *Processor) Wait() {
+	p.wg.Wait()
+}
 
-	fromEnd := true
-	if seek == io.SeekStart {
-		fromEnd = false
+// Reload will swap the metrics of every file for the ones in the config, between two
+// lines. The files keep their place. Files that were skipped because they didn't have
+// any metrics are started from the end if they have some now.
+func (p *Processor) Reload(config *conf.Config) {
+	p.lock.Lock()
+	p.current = config
+	for _, f := range p.files {
+		set := newMetricSet(config, f.path, p.out)
+		if len(set.defs) == 0 {
+			p.log.WithField("path", f.path).Warn("There are no metrics for the file after reloading")
+		}
+
+		// only the latest set matters, and nothing else sends so there is room after this
+		select {
+		case <-f.reloads:
+		default:
+		}
+		f.reloads <- set
 	}
+	skipped := p.skipped
+	p.skipped = nil
+	p.lock.Unlock()
 
+	for _, path := range skipped {
+		p.processFile(path, true)
+	}
+}
+
+// newMetricSet picks the metrics and dims for the path, an empty path isn't any file in
+// particular and only gets the top level ones
+func newMetricSet(config *conf.Config, path string, out sink.Sink) *metricSet {
+	set := &metricSet{
+		defs:    config.Metrics,
+		dims:    make(map[string]interface{}),
+		out:     out,
+		subject: config.Subject,
+	}
+	// the more specific dims win, and the fields of a line win over all of them
+	if config.Dims != nil {
+		for k, v := range *config.Dims {
+			set.dims[k] = v
+		}
+	}
+	if path == "" {
+		return set
+	}
+	if src := config.SourceFor(path); src != nil {
+		set.source = src.Path
+		set.defs = src.Metrics
+		for k, v := range src.Dims {
+			set.dims[k] = v
+		}
+		if src.Subject != "" {
+			set.subject = src.Subject
+			set.out = sink.WithSubject(out, src.Subject)
+		}
+	}
+	if config.SourceDim != "" {
+		set.dims[config.SourceDim] = path
+	}
+	return set
+}
+
+func (p *Processor) processFile(path string, fromEnd bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stopped {
+		return
+	}
+
+	log := p.log.WithField("path", path)
+	log.Info("Found file to process")
+
+	set := newMetricSet(p.current, path, p.out)
+	if set.source != "" {
+		log = log.WithField("source", set.source)
+	}
+	if len(set.defs) == 0 {
+		log.Warn("There are no metrics for the file, skipping it")
+		p.skipped = append(p.skipped, path)
+		return
+	}
+
+	config := p.config
 	consumer := newConsumer(path, log.WithField("component", "watcher"))
 	consumer.FromEnd = fromEnd
-	consumer.Follow = follow
+	consumer.Follow = p.follow
+	consumer.SkipLongLines = config.LongLines == conf.SkipLongLines
+	if config.MaxLineLength > 0 {
+		consumer.MaxLineLength = config.MaxLineLength
+	}
+	if p.store != nil {
+		consumer.Checkpoints = p.store
+		consumer.CheckpointInterval = time.Duration(config.CheckpointConf.Interval) * time.Second
+		consumer.Fallback = config.CheckpointConf.Fallback
+	}
+
+	f := &tailedFile{
+		path:     path,
+		consumer: consumer,
+		reloads:  make(chan *metricSet, 1),
+	}
+	p.files = append(p.files, f)
+	p.wg.Add(1)
+
 	go consumer.consume()
 
+	position := asString(io.SeekStart)
+	if fromEnd {
+		position = asString(io.SeekEnd)
+	}
 	log.WithFields(logrus.Fields{
-		"position": asString(seek),
-		"subject":  config.Subject,
+		"position": position,
+		"subject":  set.subject,
+		"metrics":  len(set.defs),
 	}).Info("Starting to tail file")
-	return processLines(consumer.Out, config.Metrics, log)
+	go func() {
+		defer p.wg.Done()
+		handleLines(consumer.Out, nil, f.reloads, set, log)
+	}()
 }
 
-func processLines(lines chan string, defs []conf.MetricDef, log *logrus.Entry) chan bool {
-	shutdown := make(chan bool)
-	go func() {
-		for {
-			select {
-			case line := <-lines:
-				stats.Increment("lines_seen")
-				text := strings.TrimSpace(line)
-				if len(text) > 0 {
-					for _, m := range defs {
-						l := log.WithField("metric_name", m.Name)
-						if fields, rawDims, ok := parsing.ParseLine(text, m.Fields, log); ok {
-							name, err := extractName(m.Name, m.MungeDef, fields)
-							if err != nil {
-								l.WithError(err).Warn("Failed to extract a name")
-								stats.Increment("failed_extraction")
-								continue
-							}
-
-							value, err := extractValue(m.ValueField, fields)
-							if err != nil {
-								l.WithError(err).Warnf("Failed to extract value from position %d.", *m.ValueField)
-								stats.Increment("failed_extraction")
-								continue
-							}
-
-							when, err := extractTimestamp(m.TimestampField, m.TimestampFormat, fields)
-							if err != nil {
-								l.WithError(err).Warn("Failed to extract a timestamp")
-								stats.Increment("failed_extraction")
-								continue
-							}
-							dims := convert(rawDims)
-							for _, field := range fields {
-								(*dims)[field.Label] = field.Value
-							}
-
-							c := metrics.NewCounter(name, nil)
-							if !when.IsZero() {
-								c.SetTimestamp(when)
-							}
-							c.CountN(value, dims)
-
-							stats.Increment("metrics_published")
-						} else {
-							stats.Increment("failed_extraction")
-						}
-					}
-				} else {
-					stats.Increment("blank_lines_seen")
-				}
-			case <-shutdown:
-				log.Info("Got shutdown message")
+// handleLines will turn each line into metrics until the lines are closed or it is
+// shutdown. A set from reloads replaces the current one before the next line.
+func handleLines(lines chan string, shutdown chan bool, reloads chan *metricSet, set *metricSet, log *logrus.Entry) {
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				log.Debug("Finished all the lines")
 				return
 			}
+			handleLine(line, set.defs, set.dims, set.out, log)
+		case next := <-reloads:
+			set = next
+			log.WithField("metrics", len(set.defs)).Info("Swapped in the reloaded metrics")
+		case <-shutdown:
+			log.Info("Got shutdown message")
+			return
+		}
+	}
+}
+
+func handleLine(line string, defs []conf.MetricDef, extraDims map[string]interface{}, out sink.Sink, log *logrus.Entry) {
+	stats.Increment("lines_seen")
+	text := strings.TrimSpace(line)
+	if len(text) == 0 {
+		stats.Increment("blank_lines_seen")
+		return
+	}
+
+	for _, m := range defs {
+		metric, err := extract(m, text, extraDims, log)
+		switch err {
+		case conf.ErrNotMatched:
+			stats.Increment("lines_not_matched")
+			continue
+		case conf.ErrDropped:
+			stats.Increment("lines_dropped")
+			continue
+		}
+		if err != nil {
+			if err != errNotParsed {
+				log.WithField("metric_name", m.Name).WithError(err).Warn("Failed to extract the metric")
+			}
+			stats.Increment("failed_extraction")
+			continue
 		}
-	}()
 
-	return shutdown
+		if err := out.Publish(metric); err != nil {
+			log.WithField("metric_name", m.Name).WithError(err).Warn("Failed to publish metric")
+			stats.Increment("failed_publish")
+			continue
+		}
+
+		stats.Increment("metrics_published")
+	}
 }
 
-func convert(in map[string]interface{}) *metrics.DimMap {
-	out := metrics.DimMap{}
-	for k, v := range in {
-		out[k] = v
+// errNotParsed is when the line can't be parsed, the reason is already logged
+var errNotParsed = errors.New("the line couldn't be parsed")
+
+func extract(m conf.MetricDef, text string, extraDims map[string]interface{}, log *logrus.Entry) (*sink.Metric, error) {
+	fields, dims, ok := m.ParseLine(text, log)
+	if !ok {
+		return nil, errNotParsed
+	}
+	if err := m.Filter(text, fields); err != nil {
+		return nil, err
+	}
+
+	name, err := extractName(m.Name, m.MungeDef, fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract a name: %v", err)
+	}
+
+	value, err := extractValue(m.ValueField, fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract value from position %d: %v", *m.ValueField, err)
+	}
+
+	when, err := extractTimestamp(m.TimestampField, m.TimestampFormat, fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract a timestamp: %v", err)
+	}
+
+	for k, v := range extraDims {
+		dims[k] = v
+	}
+	for _, field := range fields {
+		dims[field.Label] = field.Value
+	}
+
+	return toMetric(m, name, value, when, dims), nil
+}
+
+func toMetric(m conf.MetricDef, name string, value int64, when time.Time, dims map[string]interface{}) *sink.Metric {
+	out := &sink.Metric{
+		Name:      name,
+		Kind:      m.Kind,
+		Value:     value,
+		Timestamp: when,
+		Dims:      dims,
+	}
+
+	switch m.Kind {
+	case "":
+		out.Kind = conf.CounterKind
+	case conf.TimerKind:
+		// the unit is checked when the metric is compiled
+		out.Duration, _ = m.Duration(value)
+	case conf.HistogramKind:
+		out.Bucket = m.Bucket(value)
 	}
-	return &out
+	return out
 }
 
 func asString(seek int) string {
@@ -188,7 +513,8 @@ func extractName(root string, def *conf.MungeDef, fields map[int]parsing.ParsedF
 		joiner = "_"
 	}
 
-	return fmt.Sprintf("%s%s%s", root, joiner, raw.Value.(string)), nil
+	// typed fields are used as they were written, e.g. a number is its digits
+	return fmt.Sprintf("%s%s%v", root, joiner, raw.Value), nil
 }
 
 func extractTimestamp(idx *int, format string, fields map[int]parsing.ParsedField) (time.Time, error) {
@@ -206,11 +532,13 @@ func extractTimestamp(idx *int, format string, fields map[int]parsing.ParsedFiel
 	if format == "" {
 		format = "msec"
 	}
+	// a field with a type has already been converted, so turn it back into text
+	text := fmt.Sprintf("%v", raw.Value)
 
 	switch format {
 	case "msec", "nano", "sec":
 		// could be a number - convert it to int64
-		if num, err := strconv.ParseInt(raw.Value.(string), 10, 64); err == nil {
+		if num, err := strconv.ParseInt(text, 10, 64); err == nil {
 			switch format {
 			case "nano":
 				return time.Unix(0, num), nil
@@ -221,7 +549,7 @@ func extractTimestamp(idx *int, format string, fields map[int]parsing.ParsedFiel
 			}
 		}
 	default:
-		return time.Parse(format, raw.Value.(string))
+		return time.Parse(format, text)
 	}
 
 	return zero, fmt.Errorf("Failed to parse timestamp from '%v'", raw.Value)
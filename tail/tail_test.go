@@ -1,17 +1,22 @@
 package tail
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
-
 	"time"
 
-	"fmt"
-
+	"github.com/rybit/extractor/aggregate"
 	"github.com/rybit/extractor/conf"
 	"github.com/rybit/extractor/parsing"
+	"github.com/rybit/extractor/sink"
 	"github.com/rybit/extractor/stats"
 	"github.com/rybit/nats_metrics"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var two = 2
@@ -37,7 +42,8 @@ func TestReadLines(t *testing.T) {
 		},
 	}
 
-	shutdown := processLines(lines, defs, tl)
+	shutdown := make(chan bool)
+	go handleLines(lines, shutdown, nil, &metricSet{defs: defs, out: sink.NewNatsSink(nil, "nowhere")}, tl)
 
 	sent := make(chan *metrics.RawMetric)
 	metrics.Init(nil, "nowhere")
@@ -88,7 +94,8 @@ func TestReadLinesWithTimestamp(t *testing.T) {
 		},
 	}
 
-	shutdown := processLines(lines, defs, tl)
+	shutdown := make(chan bool)
+	go handleLines(lines, shutdown, nil, &metricSet{defs: defs, out: sink.NewNatsSink(nil, "nowhere")}, tl)
 
 	sent := make(chan *metrics.RawMetric)
 	metrics.Init(nil, "nowhere")
@@ -120,3 +127,482 @@ func TestReadLinesWithTimestamp(t *testing.T) {
 	assert.EqualValues(t, 0, stats.Get("blank_lines_seen"))
 	assert.EqualValues(t, 2, stats.Get("lines_seen"))
 }
+
+func TestReadLinesWithRegex(t *testing.T) {
+	stats.Reset()
+	lines := make(chan string)
+	three := 3
+	defs := []conf.MetricDef{
+		{
+			Name:    "testing-regex",
+			Format:  conf.RegexFormat,
+			Pattern: `^(?P<hero>\w+) fought (?P<villain>[\w ]+) for (?P<mins>\d+) minutes$`,
+			Fields: []parsing.FieldDef{
+				{Group: "hero"},
+				{Group: "villain"},
+				{Group: "mins", Type: parsing.NumberType},
+			},
+			// the mins group is the third capture group
+			ValueField: &three,
+		},
+	}
+	for i := range defs {
+		assert.NoError(t, defs[i].Compile())
+	}
+
+	shutdown := make(chan bool)
+	go handleLines(lines, shutdown, nil, &metricSet{defs: defs, out: sink.NewNatsSink(nil, "nowhere")}, tl)
+
+	sent := make(chan *metrics.RawMetric)
+	metrics.Init(nil, "nowhere")
+	metrics.Trace(func(rm *metrics.RawMetric) {
+		sent <- rm
+	})
+
+	lines <- "batman fought the joker for 12 minutes"
+	lines <- "robin watched"
+
+	select {
+	case rm := <-sent:
+		assert.Equal(t, "testing-regex", rm.Name)
+		assert.EqualValues(t, 12, rm.Value)
+		assert.Equal(t, 2, len(rm.Dims))
+		assert.Equal(t, "batman", rm.Dims["hero"])
+		assert.Equal(t, "the joker", rm.Dims["villain"])
+		shutdown <- true
+	case <-time.After(time.Second):
+		assert.FailNow(t, "Failed to get a new metric inside of a second")
+	}
+
+	assert.EqualValues(t, 1, stats.Get("metrics_published"))
+	assert.EqualValues(t, 1, stats.Get("failed_extraction"))
+	assert.EqualValues(t, 2, stats.Get("lines_seen"))
+}
+
+func TestReadLinesWithKinds(t *testing.T) {
+	stats.Reset()
+	lines := make(chan string)
+	one := 1
+	fields := []parsing.FieldDef{
+		{Position: 0},
+		{Position: 1},
+	}
+	defs := []conf.MetricDef{
+		{Name: "testing-gauge", Kind: conf.GaugeKind, Fields: fields, ValueField: &one},
+		{Name: "testing-timer", Kind: conf.TimerKind, Fields: fields, ValueField: &one},
+		{Name: "testing-histogram", Kind: conf.HistogramKind, Fields: fields, ValueField: &one, Buckets: []int64{10, 100, 1000}},
+	}
+	for i := range defs {
+		assert.NoError(t, defs[i].Compile())
+	}
+
+	out := make(chanSink, 3)
+	shutdown := make(chan bool)
+	go handleLines(lines, shutdown, nil, &metricSet{defs: defs, dims: map[string]interface{}{"host": "gotham"}, out: out}, tl)
+
+	lines <- "hero=batman dur=250"
+
+	found := map[string]*sink.Metric{}
+	for len(found) < 3 {
+		select {
+		case m := <-out:
+			found[m.Name] = m
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Failed to get the metrics inside of a second")
+		}
+	}
+	shutdown <- true
+
+	if m := found["testing-gauge"]; assert.NotNil(t, m) {
+		assert.Equal(t, conf.GaugeKind, m.Kind)
+		assert.EqualValues(t, 250, m.Value)
+		assert.Equal(t, "gotham", m.Dims["host"])
+	}
+	if m := found["testing-timer"]; assert.NotNil(t, m) {
+		assert.Equal(t, conf.TimerKind, m.Kind)
+		assert.Equal(t, 250*time.Millisecond, m.Duration)
+	}
+	if m := found["testing-histogram"]; assert.NotNil(t, m) {
+		assert.Equal(t, conf.HistogramKind, m.Kind)
+		assert.Equal(t, "1000", m.Bucket)
+	}
+	assert.EqualValues(t, 3, stats.Get("metrics_published"))
+}
+
+func TestReadLinesWithConditions(t *testing.T) {
+	stats.Reset()
+	one := 1
+	low := 500.0
+	defs := []conf.MetricDef{{
+		Name:   "testing-errors",
+		Fields: []parsing.FieldDef{{Position: 0}, {Position: 1, Type: parsing.NumberType}},
+		Match:  &conf.Condition{FieldIndex: &one, Min: &low},
+		Drop:   &conf.Condition{Line: "path=/health"},
+	}}
+	require.NoError(t, defs[0].Compile())
+
+	out := make(chanSink, 10)
+	for _, line := range []string{
+		"path=/users status=200",
+		"path=/health status=503",
+		"path=/users status=502",
+	} {
+		handleLine(line, defs, nil, out, tl)
+	}
+
+	require.Len(t, out, 1)
+	m := <-out
+	assert.Equal(t, 502, m.Dims["status"])
+	assert.EqualValues(t, 1, stats.Get("metrics_published"))
+	assert.EqualValues(t, 1, stats.Get("lines_not_matched"))
+	assert.EqualValues(t, 1, stats.Get("lines_dropped"))
+	assert.EqualValues(t, 0, stats.Get("failed_extraction"))
+}
+
+func TestCompileKinds(t *testing.T) {
+	one := 1
+	m := conf.MetricDef{Name: "no-value", Kind: conf.GaugeKind}
+	assert.Error(t, m.Compile())
+
+	m = conf.MetricDef{Name: "nonsense", Kind: "nonsense", ValueField: &one}
+	assert.Error(t, m.Compile())
+
+	m = conf.MetricDef{Name: "no-buckets", Kind: conf.HistogramKind, ValueField: &one}
+	assert.Error(t, m.Compile())
+
+	m = conf.MetricDef{Name: "bad-buckets", Kind: conf.HistogramKind, ValueField: &one, Buckets: []int64{10, 5}}
+	assert.Error(t, m.Compile())
+
+	m = conf.MetricDef{Name: "bad-unit", Kind: conf.TimerKind, ValueField: &one, TimerUnit: "weeks"}
+	assert.Error(t, m.Compile())
+
+	m = conf.MetricDef{Name: "histogram", Kind: conf.HistogramKind, ValueField: &one, Buckets: []int64{10, 100}}
+	if assert.NoError(t, m.Compile()) {
+		assert.Equal(t, "10", m.Bucket(10))
+		assert.Equal(t, "100", m.Bucket(11))
+		assert.Equal(t, "+Inf", m.Bucket(101))
+	}
+}
+
+type chanSink chan *sink.Metric
+
+func (c chanSink) Name() string { return "chan" }
+func (c chanSink) Flush() error { return nil }
+func (c chanSink) Publish(m *sink.Metric) error {
+	c <- m
+	return nil
+}
+
+func TestReadLinesAggregated(t *testing.T) {
+	stats.Reset()
+	lines := make(chan string)
+	one := 1
+	defs := []conf.MetricDef{
+		{Name: "testing-count", Fields: []parsing.FieldDef{{Position: 0}}},
+		{Name: "testing-dur", Kind: conf.TimerKind, Fields: []parsing.FieldDef{{Position: 0}, {Position: 1}}, ValueField: &one},
+	}
+	for i := range defs {
+		assert.NoError(t, defs[i].Compile())
+	}
+
+	out := make(chanSink, 20)
+	agg := aggregate.New(&aggregate.Config{Interval: 60, Percentiles: []float64{50}}, out, tl)
+	shutdown := make(chan bool)
+	go handleLines(lines, shutdown, nil, &metricSet{defs: defs, out: agg}, tl)
+
+	lines <- "hero=batman dur=10"
+	lines <- "hero=batman dur=30"
+	lines <- "hero=robin dur=20"
+	shutdown <- true
+	assert.NoError(t, agg.Flush())
+	close(out)
+
+	found := map[string]*sink.Metric{}
+	for m := range out {
+		found[fmt.Sprintf("%s/%v", m.Name, m.Dims["hero"])] = m
+	}
+
+	assert.EqualValues(t, 2, found["testing-count/batman"].Value)
+	assert.EqualValues(t, 1, found["testing-count/robin"].Value)
+	assert.EqualValues(t, 2, found["testing-dur.count/batman"].Value)
+	assert.EqualValues(t, 40, found["testing-dur.sum/batman"].Value)
+	assert.EqualValues(t, 30, found["testing-dur.max/batman"].Value)
+	assert.EqualValues(t, 20, found["testing-dur.p50/robin"].Value)
+}
+
+func TestProcessFilesWithGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, line string) {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+	write("one.log", "hero=batman")
+	write("two.log", "hero=robin")
+	write("ignored.txt", "hero=joker")
+
+	config := &conf.Config{
+		RetrySec:  1,
+		SourceDim: "source",
+		Metrics:   []conf.MetricDef{{Name: "testing-glob", Fields: []parsing.FieldDef{{Position: 0}}}},
+	}
+	out := make(chanSink, 10)
+	ProcessFiles(config, []string{filepath.Join(dir, "*.log")}, out, tl, io.SeekStart, true)
+
+	// a new file is picked up from the beginning
+	write("three.log", "hero=alfred")
+
+	found := map[string]string{}
+	for len(found) < 3 {
+		select {
+		case m := <-out:
+			found[m.Dims["hero"].(string)] = m.Dims["source"].(string)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Failed to get metrics from all the files", "found: %v", found)
+		}
+	}
+
+	assert.Equal(t, filepath.Join(dir, "one.log"), found["batman"])
+	assert.Equal(t, filepath.Join(dir, "two.log"), found["robin"])
+	assert.Equal(t, filepath.Join(dir, "three.log"), found["alfred"])
+}
+
+func TestProcessFilesForgetsRemovedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "one.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hero=batman\n"), 0644))
+
+	config := &conf.Config{
+		RetrySec: 1,
+		Metrics:  []conf.MetricDef{{Name: "testing-forget", Fields: []parsing.FieldDef{{Position: 0}}}},
+	}
+	out := make(chanSink, 10)
+	p := ProcessFiles(config, []string{filepath.Join(dir, "*.log")}, out, tl, io.SeekStart, true)
+	defer p.Stop()
+
+	next := func() *sink.Metric {
+		select {
+		case m := <-out:
+			return m
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Failed to get a metric")
+		}
+		return nil
+	}
+	assert.Equal(t, "batman", next().Dims["hero"])
+
+	require.NoError(t, os.Remove(path))
+	forgotten := func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return len(p.files) == 0
+	}
+	for start := time.Now(); !forgotten(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			require.FailNow(t, "Failed to stop the removed file")
+		}
+	}
+
+	// it is a new file when it matches again
+	require.NoError(t, ioutil.WriteFile(path, []byte("hero=robin\n"), 0644))
+	assert.Equal(t, "robin", next().Dims["hero"])
+}
+
+func TestProcessFilesWithSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	access := filepath.Join(dir, "access.log")
+	errs := filepath.Join(dir, "error.log")
+	require.NoError(t, ioutil.WriteFile(access, []byte("status=200\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(errs, []byte("level=error\n"), 0644))
+
+	config := &conf.Config{
+		RetrySec: 1,
+		Dims:     &map[string]interface{}{"app": "default", "host": "gotham"},
+		Sources: []conf.SourceDef{
+			{
+				Path:    access,
+				Metrics: []conf.MetricDef{{Name: "testing-access", Fields: []parsing.FieldDef{{Position: 0}}}},
+				Dims:    map[string]interface{}{"app": "nginx"},
+			},
+			{
+				Path:    filepath.Join(dir, "err*.log"),
+				Metrics: []conf.MetricDef{{Name: "testing-errors", Fields: []parsing.FieldDef{{Position: 0}}}},
+				Subject: "errors",
+			},
+		},
+	}
+	for i := range config.Sources {
+		require.NoError(t, config.Sources[i].Compile())
+	}
+
+	out := make(chanSink, 10)
+	ProcessFiles(config, config.SourcePaths(), out, tl, io.SeekStart, false)
+
+	found := map[string]*sink.Metric{}
+	for len(found) < 2 {
+		select {
+		case m := <-out:
+			found[m.Name] = m
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "Failed to get metrics from both sources", "found: %v", found)
+		}
+	}
+
+	if m := found["testing-access"]; assert.NotNil(t, m) {
+		assert.Equal(t, "200", m.Dims["status"])
+		assert.Equal(t, "nginx", m.Dims["app"])
+		assert.Equal(t, "gotham", m.Dims["host"])
+		assert.Empty(t, m.Subject)
+	}
+	if m := found["testing-errors"]; assert.NotNil(t, m) {
+		assert.Equal(t, "error", m.Dims["level"])
+		assert.Equal(t, "default", m.Dims["app"])
+		assert.Equal(t, "gotham", m.Dims["host"])
+		assert.Equal(t, "errors", m.Subject)
+	}
+}
+
+func TestProcessorStopDrainsLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "drain.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hero=batman\nhero=robin"), 0644))
+
+	config := &conf.Config{
+		RetrySec: 1,
+		Metrics:  []conf.MetricDef{{Name: "testing-drain", Fields: []parsing.FieldDef{{Position: 0}}}},
+	}
+	out := make(chanSink, 10)
+	p := ProcessFiles(config, []string{path}, out, tl, io.SeekStart, true)
+
+	select {
+	case m := <-out:
+		assert.Equal(t, "batman", m.Dims["hero"])
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Failed to get the first metric")
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	require.NoError(t, waitFor(stopped, 5))
+
+	// the line without a newline is sent when the file is stopped
+	select {
+	case m := <-out:
+		assert.Equal(t, "robin", m.Dims["hero"])
+	default:
+		assert.Fail(t, "The last line wasn't processed before stopping")
+	}
+}
+
+func TestProcessorWaitWithoutFollowing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "one.log"), []byte("hero=batman\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "two.log"), []byte("hero=robin\n"), 0644))
+
+	config := &conf.Config{
+		RetrySec: 1,
+		Metrics:  []conf.MetricDef{{Name: "testing-wait", Fields: []parsing.FieldDef{{Position: 0}}}},
+	}
+	out := make(chanSink, 10)
+	p := ProcessFiles(config, []string{filepath.Join(dir, "*.log")}, out, tl, io.SeekStart, false)
+
+	finished := make(chan bool)
+	go func() {
+		p.Wait()
+		close(finished)
+	}()
+	require.NoError(t, waitFor(finished, 5))
+	assert.Len(t, out, 2)
+}
+
+func TestProcessorReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "reload.log")
+	skipped := filepath.Join(dir, "skipped.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hero=batman\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(skipped, []byte{}, 0644))
+
+	config := &conf.Config{
+		RetrySec: 1,
+		Dims:     &map[string]interface{}{"env": "staging"},
+		Sources: []conf.SourceDef{{
+			Path:    path,
+			Metrics: []conf.MetricDef{{Name: "testing-before", Fields: []parsing.FieldDef{{Position: 0}}}},
+		}},
+	}
+	out := make(chanSink, 10)
+	p := ProcessFiles(config, []string{path, skipped}, out, tl, io.SeekStart, true)
+	defer p.Stop()
+
+	select {
+	case m := <-out:
+		assert.Equal(t, "testing-before", m.Name)
+		assert.Equal(t, "staging", m.Dims["env"])
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Failed to get the first metric")
+	}
+
+	p.Reload(&conf.Config{
+		RetrySec:  1,
+		SourceDim: "source",
+		Dims:      &map[string]interface{}{"env": "production"},
+		Metrics:   []conf.MetricDef{{Name: "testing-after", Fields: []parsing.FieldDef{{Position: 0}}}},
+	})
+
+	write := func(path, line string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+
+	// the files keep their place, so only the new lines are read
+	write(path, "hero=robin")
+
+	// the skipped file starts from whatever the end is when it is opened
+	found := map[string]*sink.Metric{}
+	timeout := time.After(5 * time.Second)
+	for len(found) < 2 {
+		if found["alfred"] == nil {
+			write(skipped, "hero=alfred")
+		}
+		select {
+		case m := <-out:
+			found[m.Dims["hero"].(string)] = m
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			require.FailNow(t, "Failed to get metrics after reloading", "found: %v", found)
+		}
+	}
+
+	for hero, source := range map[string]string{"robin": path, "alfred": skipped} {
+		if m := found[hero]; assert.NotNil(t, m, hero) {
+			assert.Equal(t, "testing-after", m.Name)
+			assert.Equal(t, source, m.Dims["source"])
+			assert.Equal(t, "production", m.Dims["env"])
+		}
+	}
+	assert.Nil(t, found["batman"])
+}
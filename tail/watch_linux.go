@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package tail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const fileEvents = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF
+const dirEvents = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+// watcher uses inotify to send on Events when the file might have changed. It
+// watches the directory too so that it can follow the path when the file is
+// replaced. Events is closed if the watcher stops.
+type watcher struct {
+	Events chan struct{}
+
+	fd     int
+	file   *os.File
+	path   string
+	name   string
+	dirWd  int
+	fileWd int
+	done   chan bool
+	log    *logrus.Entry
+}
+
+func newWatcher(path string, log *logrus.Entry) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &watcher{
+		Events: make(chan struct{}, 1),
+		fd:     fd,
+		// it is non-blocking so reads go through the runtime poller and stop on close
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		path:   path,
+		name:   filepath.Base(path),
+		fileWd: -1,
+		done:   make(chan bool),
+		log:    log,
+	}
+
+	w.dirWd, err = syscall.InotifyAddWatch(fd, filepath.Dir(path), dirEvents)
+	if err != nil {
+		w.file.Close()
+		// ENOSPC here means we are out of watches
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// the file might not be there yet, it is watched once it is created
+	if err := w.watchFile(); err != nil && !os.IsNotExist(err) {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Close will stop the watcher, Events is closed once it has stopped
+func (w *watcher) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *watcher) watchFile() error {
+	wd, err := syscall.InotifyAddWatch(w.fd, w.path, fileEvents)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	if w.fileWd >= 0 && w.fileWd != wd {
+		// we only care about whatever is at the path now
+		syscall.InotifyRmWatch(w.fd, uint32(w.fileWd))
+	}
+	w.fileWd = wd
+	return nil
+}
+
+func (w *watcher) run() {
+	defer close(w.Events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				w.log.WithError(err).Warn("Failed to read inotify events")
+			}
+			return
+		}
+
+		offset := 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(event.Len)
+
+			name := ""
+			if event.Len > 0 && offset <= n {
+				name = strings.TrimRight(string(buf[start:offset]), "\x00")
+			}
+			w.handle(int(event.Wd), event.Mask, name)
+		}
+	}
+}
+
+func (w *watcher) handle(wd int, mask uint32, name string) {
+	switch {
+	case mask&syscall.IN_Q_OVERFLOW != 0:
+		w.log.Debug("The inotify queue overflowed")
+		w.wake()
+	case wd == w.dirWd:
+		if name != w.name {
+			return
+		}
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			if err := w.watchFile(); err != nil {
+				w.log.WithError(err).Warn("Failed to watch the new file")
+			}
+		}
+		w.wake()
+	case mask&syscall.IN_IGNORED == 0:
+		w.wake()
+	}
+}
+
+// wake doesn't block, if there is already an event waiting they are the same
+func (w *watcher) wake() {
+	select {
+	case w.Events <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,74 @@
+package tail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "watched.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("first\n"), 0644))
+
+	w, err := newWatcher(path, tl)
+	require.NoError(t, err)
+
+	expectEvent := func(msg string) {
+		select {
+		case _, ok := <-w.Events:
+			require.True(t, ok, "the events were closed while waiting for "+msg)
+		case <-time.After(time.Second):
+			require.FailNow(t, "Failed to get an event for "+msg)
+		}
+		// let the rest of the events for the change come in and drop them
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case <-w.Events:
+		default:
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	f.WriteString("second\n")
+	f.Close()
+	expectEvent("the write")
+
+	// other files in the directory are ignored
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other.log"), []byte("other\n"), 0644))
+	select {
+	case <-w.Events:
+		assert.Fail(t, "Got an event for another file")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, os.Rename(path, path+".1"))
+	expectEvent("the rotation")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("new\n"), 0644))
+	expectEvent("the new file")
+
+	// the new file is watched
+	f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	f.WriteString("more\n")
+	f.Close()
+	expectEvent("the write to the new file")
+
+	require.NoError(t, w.Close())
+	select {
+	case _, ok := <-w.Events:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		assert.Fail(t, "The events weren't closed")
+	}
+}
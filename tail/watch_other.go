@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package tail
+
+import (
+	"errors"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// watcher is only implemented with inotify, everywhere else the file is polled
+type watcher struct {
+	Events chan struct{}
+}
+
+func newWatcher(path string, log *logrus.Entry) (*watcher, error) {
+	return nil, errors.New("watching files is only supported on linux")
+}
+
+func (w *watcher) Close() error {
+	return nil
+}